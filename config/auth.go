@@ -0,0 +1,67 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+var (
+	// Return the token mode, either opaque or signed
+	GetAuthMode = getAuthMode
+
+	// Return how long a login session is valid before it has to be refreshed
+	GetAuthSessionTTL = getAuthSessionTTL
+
+	// Return how long a signed token is valid
+	GetAuthSignedTokenTTL = getAuthSignedTokenTTL
+
+	// Return the id of the key which signs new tokens
+	GetAuthSigningKeyId = getAuthSigningKeyId
+
+	// Return how long permissions of a role are cached
+	GetAuthPermissionCacheTTL = getAuthPermissionCacheTTL
+
+	// Return the secret of a verification key, or an empty string if the key
+	// id is unknown
+	GetAuthKey = getAuthKey
+
+	// Return whether requests may authenticate with a username and password
+	// in the Authorization header
+	GetAuthBasicEnabled = getAuthBasicEnabled
+
+	// Return the realm which is sent in authentication challenges
+	GetAuthRealm = getAuthRealm
+)
+
+func getAuthMode() string {
+	return getConfigString("auth.mode")
+}
+
+func getAuthSessionTTL() time.Duration {
+	return getConfigDuration("auth.session_ttl")
+}
+
+func getAuthSignedTokenTTL() time.Duration {
+	return getConfigDuration("auth.signed_token_ttl")
+}
+
+func getAuthSigningKeyId() string {
+	return getConfigString("auth.signing_key")
+}
+
+func getAuthPermissionCacheTTL() time.Duration {
+	return getConfigDuration("auth.permission_cache_ttl")
+}
+
+func getAuthKey(keyId string) string {
+	// Viper stores map keys in lower case
+	return getConfigStringMap("auth.keys")[strings.ToLower(keyId)]
+}
+
+func getAuthBasicEnabled() bool {
+	return getConfigBool("auth.basic_enabled")
+}
+
+func getAuthRealm() string {
+	return getConfigString("auth.realm")
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"time"
+)
+
+var (
+	// Return how long a copy can be borrowed before it is due
+	GetCirculationLoanPeriod = getCirculationLoanPeriod
+
+	// Return how much a renewal extends the due date of a loan
+	GetCirculationRenewalPeriod = getCirculationRenewalPeriod
+
+	// Return how many times a single loan can be renewed
+	GetCirculationMaxRenewals = getCirculationMaxRenewals
+
+	// Return how long a reserved copy waits for the member to pick it up
+	GetCirculationHoldPickupPeriod = getCirculationHoldPickupPeriod
+
+	// Return how many concurrent loans a user can have. A limit for the
+	// member category takes precedence over the limit for the name of the
+	// user role. Zero means unlimited.
+	GetCirculationMaxLoans = getCirculationMaxLoans
+
+	// Return whether members with overdue loans are blocked from borrowing
+	GetCirculationBlockOnOverdue = getCirculationBlockOnOverdue
+)
+
+func getCirculationLoanPeriod() time.Duration {
+	return getConfigDuration("circulation.loan_period")
+}
+
+func getCirculationRenewalPeriod() time.Duration {
+	return getConfigDuration("circulation.renewal_period")
+}
+
+func getCirculationMaxRenewals() int {
+	return getConfigInt("circulation.max_renewals")
+}
+
+func getCirculationHoldPickupPeriod() time.Duration {
+	return getConfigDuration("circulation.hold_pickup_period")
+}
+
+func getCirculationMaxLoans(roleName, memberCategory string) int {
+	if memberCategory != "" {
+		key := "circulation.max_loans_by_category." + memberCategory
+		if isConfigSet(key) {
+			return getConfigInt(key)
+		}
+	}
+
+	if roleName != "" {
+		key := "circulation.max_loans." + roleName
+		if isConfigSet(key) {
+			return getConfigInt(key)
+		}
+	}
+
+	return getConfigInt("circulation.max_loans.default")
+}
+
+func getCirculationBlockOnOverdue() bool {
+	return getConfigBool("circulation.block_on_overdue")
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestGetCirculationMaxLoans(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("circulation.max_loans.default", 5)
+	viper.Set("circulation.max_loans.circulation_desk", 10)
+	viper.Set("circulation.max_loans_by_category.student", 3)
+
+	tests := []struct {
+		roleName       string
+		memberCategory string
+		expected       int
+	}{
+		{"circulation_desk", "", 10},
+		{"cataloguer", "", 5},
+		{"circulation_desk", "student", 3},
+		{"circulation_desk", "staff", 10},
+	}
+
+	for _, test := range tests {
+		maxLoans := getCirculationMaxLoans(test.roleName, test.memberCategory)
+		if maxLoans != test.expected {
+			t.Errorf(
+				"Expected %d loans for %q %q, got %d",
+				test.expected,
+				test.roleName,
+				test.memberCategory,
+				maxLoans,
+			)
+		}
+	}
+}
@@ -10,6 +10,8 @@ import (
 var InitConfig = initConfig
 
 func initConfig(filename string, additionalDirs []string) error {
+	setDefaults()
+
 	viper.SetConfigName(filename)
 	viper.AddConfigPath(".")
 	viper.AddConfigPath("$HOME")
@@ -31,6 +33,89 @@ func initConfig(filename string, additionalDirs []string) error {
 	return nil
 }
 
+// Default values for settings which can be left out of the config file
+func setDefaults() {
+	viper.SetDefault("log.format", "json")
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.bodies", false)
+	viper.SetDefault("log.body_max_size", 4096)
+	viper.SetDefault("log.redact_fields", []string{
+		"Password",
+		"OldPassword",
+		"NewPassword",
+		"Token",
+		"Key",
+		"ApiKey",
+		"Secret",
+		"ClientSecret",
+	})
+
+	viper.SetDefault("http.middleware", []string{
+		"request_id",
+		"access_log",
+		"recover",
+		"cors",
+		"timeout",
+		"rate_limit",
+		"auth",
+		"gzip",
+	})
+	viper.SetDefault("http.request_timeout", "30s")
+	viper.SetDefault("http.cors.allowed_origins", []string{})
+	viper.SetDefault(
+		"http.cors.allowed_headers",
+		[]string{"Authorization", "Content-Type"},
+	)
+	viper.SetDefault("http.cors.max_age", "10m")
+	viper.SetDefault("http.rate_limit.requests_per_minute", 600)
+	viper.SetDefault("http.rate_limit.burst", 60)
+	viper.SetDefault("http.gzip.min_size", 1024)
+
+	viper.SetDefault("auth.mode", "opaque")
+	viper.SetDefault("auth.session_ttl", "24h")
+	viper.SetDefault("auth.signed_token_ttl", "15m")
+	viper.SetDefault("auth.permission_cache_ttl", "1m")
+	viper.SetDefault("auth.basic_enabled", false)
+	viper.SetDefault("auth.realm", "bookstore")
+
+	viper.SetDefault("login.max_failed_attempts", 5)
+	viper.SetDefault("login.max_failed_attempts_per_ip", 20)
+	viper.SetDefault("login.lockout_period", "15m")
+	viper.SetDefault("login.backoff_base", "1s")
+	viper.SetDefault("login.backoff_max", "30s")
+	viper.SetDefault("login.failure_window", "1h")
+
+	viper.SetDefault("password.min_length", 8)
+	viper.SetDefault("password.require_digit", false)
+	viper.SetDefault("password.require_mixed_case", false)
+	viper.SetDefault("password.reset_token_ttl", "1h")
+
+	viper.SetDefault("notifier.type", "file")
+	viper.SetDefault("notifier.file_path", "notifications.log")
+
+	viper.SetDefault("oidc.enabled", false)
+	viper.SetDefault("oidc.scopes", []string{"openid", "email", "profile"})
+	viper.SetDefault("oidc.username_claim", "email")
+	viper.SetDefault("oidc.auto_provision", false)
+	viper.SetDefault("oidc.state_ttl", "10m")
+	viper.SetDefault("oidc.http_timeout", "10s")
+
+	viper.SetDefault("circulation.loan_period", "336h")
+	viper.SetDefault("circulation.renewal_period", "336h")
+	viper.SetDefault("circulation.max_renewals", 2)
+	viper.SetDefault("circulation.hold_pickup_period", "72h")
+	viper.SetDefault("circulation.block_on_overdue", true)
+	viper.SetDefault("circulation.max_loans.default", 5)
+
+	viper.SetDefault("fines.daily_rate", 25)
+	viper.SetDefault("fines.max_amount", 1000)
+	viper.SetDefault("fines.borrow_block_threshold", 500)
+}
+
+func isConfigSet(key string) bool {
+	return viper.IsSet(key)
+}
+
 func getConfigString(key string) string {
 	return viper.GetString(key)
 }
@@ -39,6 +124,26 @@ func getConfigInt(key string) int {
 	return viper.GetInt(key)
 }
 
+func getConfigInt64(key string) int64 {
+	return viper.GetInt64(key)
+}
+
+func getConfigBool(key string) bool {
+	return viper.GetBool(key)
+}
+
+func getConfigStringSlice(key string) []string {
+	return viper.GetStringSlice(key)
+}
+
+func getConfigStringMapStringSlice(key string) map[string][]string {
+	return viper.GetStringMapStringSlice(key)
+}
+
+func getConfigStringMap(key string) map[string]string {
+	return viper.GetStringMapString(key)
+}
+
 func getConfigDuration(key string) time.Duration {
 	return viper.GetDuration(key)
 }
@@ -0,0 +1,24 @@
+package config
+
+var (
+	// Return the fine in cents charged for each day a loan is overdue
+	GetFineDailyRate = getFineDailyRate
+
+	// Return the maximum fine in cents charged for a single loan
+	GetFineMaxAmount = getFineMaxAmount
+
+	// Return the fine balance in cents above which a member can't borrow
+	GetFineBorrowBlockThreshold = getFineBorrowBlockThreshold
+)
+
+func getFineDailyRate() int64 {
+	return getConfigInt64("fines.daily_rate")
+}
+
+func getFineMaxAmount() int64 {
+	return getConfigInt64("fines.max_amount")
+}
+
+func getFineBorrowBlockThreshold() int64 {
+	return getConfigInt64("fines.borrow_block_threshold")
+}
@@ -6,6 +6,30 @@ var (
 	GetHTTPServerAddress = getHTTPServerAddress
 	GetHTTPReadTimeout   = getHTTPReadTimeout
 	GetHTTPWriteTimeout  = getHTTPWriteTimeout
+
+	// Return the names of the middleware which wrap the API, outermost first
+	GetHTTPMiddleware = getHTTPMiddleware
+
+	// Return how long a request may be processed before it is cancelled
+	GetHTTPRequestTimeout = getHTTPRequestTimeout
+
+	// Return the origins which may call the API from a browser
+	GetHTTPCORSAllowedOrigins = getHTTPCORSAllowedOrigins
+
+	// Return the request headers which browsers may send to the API
+	GetHTTPCORSAllowedHeaders = getHTTPCORSAllowedHeaders
+
+	// Return how long browsers may cache the response to a preflight request
+	GetHTTPCORSMaxAge = getHTTPCORSMaxAge
+
+	// Return how many requests a client may make per minute
+	GetHTTPRateLimitPerMinute = getHTTPRateLimitPerMinute
+
+	// Return how many requests a client may make at once above the rate
+	GetHTTPRateLimitBurst = getHTTPRateLimitBurst
+
+	// Return the smallest response which is compressed
+	GetHTTPGzipMinSize = getHTTPGzipMinSize
 )
 
 func getHTTPServerAddress() string {
@@ -19,3 +43,35 @@ func getHTTPReadTimeout() time.Duration {
 func getHTTPWriteTimeout() time.Duration {
 	return getConfigDuration("http.write_timeout")
 }
+
+func getHTTPMiddleware() []string {
+	return getConfigStringSlice("http.middleware")
+}
+
+func getHTTPRequestTimeout() time.Duration {
+	return getConfigDuration("http.request_timeout")
+}
+
+func getHTTPCORSAllowedOrigins() []string {
+	return getConfigStringSlice("http.cors.allowed_origins")
+}
+
+func getHTTPCORSAllowedHeaders() []string {
+	return getConfigStringSlice("http.cors.allowed_headers")
+}
+
+func getHTTPCORSMaxAge() time.Duration {
+	return getConfigDuration("http.cors.max_age")
+}
+
+func getHTTPRateLimitPerMinute() int {
+	return getConfigInt("http.rate_limit.requests_per_minute")
+}
+
+func getHTTPRateLimitBurst() int {
+	return getConfigInt("http.rate_limit.burst")
+}
+
+func getHTTPGzipMinSize() int {
+	return getConfigInt("http.gzip.min_size")
+}
@@ -0,0 +1,48 @@
+package config
+
+var (
+	// Return the format of log lines, either json or text
+	GetLogFormat = getLogFormat
+
+	// Return the lowest level which is logged, one of debug, info, warn and
+	// error
+	GetLogLevel = getLogLevel
+
+	// Return whether the bodies of requests and responses are logged
+	GetLogBodies = getLogBodies
+
+	// Return the number of bytes after which logged bodies are truncated
+	GetLogBodyMaxSize = getLogBodyMaxSize
+
+	// Return the fields which are masked in logged bodies and query strings
+	// of every route
+	GetLogRedactFields = getLogRedactFields
+
+	// Return the fields which are masked in addition for some routes, by the
+	// path pattern of the route
+	GetLogRedactRoutes = getLogRedactRoutes
+)
+
+func getLogFormat() string {
+	return getConfigString("log.format")
+}
+
+func getLogLevel() string {
+	return getConfigString("log.level")
+}
+
+func getLogBodies() bool {
+	return getConfigBool("log.bodies")
+}
+
+func getLogBodyMaxSize() int {
+	return getConfigInt("log.body_max_size")
+}
+
+func getLogRedactFields() []string {
+	return getConfigStringSlice("log.redact_fields")
+}
+
+func getLogRedactRoutes() map[string][]string {
+	return getConfigStringMapStringSlice("log.redact_routes")
+}
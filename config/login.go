@@ -0,0 +1,48 @@
+package config
+
+import "time"
+
+var (
+	// Return the number of failed logins of a username before it is locked
+	GetLoginMaxFailedAttempts = getLoginMaxFailedAttempts
+
+	// Return the number of failed logins from an IP before it is locked
+	GetLoginMaxFailedAttemptsPerIP = getLoginMaxFailedAttemptsPerIP
+
+	// Return how long a username or IP stays locked
+	GetLoginLockoutPeriod = getLoginLockoutPeriod
+
+	// Return the delay after the first failed login, it doubles with every
+	// further failure
+	GetLoginBackoffBase = getLoginBackoffBase
+
+	// Return the longest delay between failed logins
+	GetLoginBackoffMax = getLoginBackoffMax
+
+	// Return the time after which failed logins are forgotten
+	GetLoginFailureWindow = getLoginFailureWindow
+)
+
+func getLoginMaxFailedAttempts() int64 {
+	return getConfigInt64("login.max_failed_attempts")
+}
+
+func getLoginMaxFailedAttemptsPerIP() int64 {
+	return getConfigInt64("login.max_failed_attempts_per_ip")
+}
+
+func getLoginLockoutPeriod() time.Duration {
+	return getConfigDuration("login.lockout_period")
+}
+
+func getLoginBackoffBase() time.Duration {
+	return getConfigDuration("login.backoff_base")
+}
+
+func getLoginBackoffMax() time.Duration {
+	return getConfigDuration("login.backoff_max")
+}
+
+func getLoginFailureWindow() time.Duration {
+	return getConfigDuration("login.failure_window")
+}
@@ -0,0 +1,17 @@
+package config
+
+var (
+	// Return the type of the notifier, either log or file
+	GetNotifierType = getNotifierType
+
+	// Return the file which the file notifier appends messages to
+	GetNotifierFilePath = getNotifierFilePath
+)
+
+func getNotifierType() string {
+	return getConfigString("notifier.type")
+}
+
+func getNotifierFilePath() string {
+	return getConfigString("notifier.file_path")
+}
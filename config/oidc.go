@@ -0,0 +1,76 @@
+package config
+
+import "time"
+
+var (
+	// Return whether login through the OpenID Connect provider is enabled
+	GetOidcEnabled = getOidcEnabled
+
+	// Return the issuer URL which the provider configuration is discovered
+	// from
+	GetOidcIssuer = getOidcIssuer
+
+	// Return the client id registered at the provider
+	GetOidcClientId = getOidcClientId
+
+	// Return the client secret registered at the provider
+	GetOidcClientSecret = getOidcClientSecret
+
+	// Return the URL which the provider redirects back to after login
+	GetOidcRedirectURL = getOidcRedirectURL
+
+	// Return the scopes requested from the provider
+	GetOidcScopes = getOidcScopes
+
+	// Return the claim which is matched against the username of a user
+	GetOidcUsernameClaim = getOidcUsernameClaim
+
+	// Return whether unknown users are created as members on first login
+	GetOidcAutoProvision = getOidcAutoProvision
+
+	// Return how long a login may take from start to callback
+	GetOidcStateTTL = getOidcStateTTL
+
+	// Return the timeout of requests to the provider
+	GetOidcHTTPTimeout = getOidcHTTPTimeout
+)
+
+func getOidcEnabled() bool {
+	return getConfigBool("oidc.enabled")
+}
+
+func getOidcIssuer() string {
+	return getConfigString("oidc.issuer")
+}
+
+func getOidcClientId() string {
+	return getConfigString("oidc.client_id")
+}
+
+func getOidcClientSecret() string {
+	return getConfigString("oidc.client_secret")
+}
+
+func getOidcRedirectURL() string {
+	return getConfigString("oidc.redirect_url")
+}
+
+func getOidcScopes() []string {
+	return getConfigStringSlice("oidc.scopes")
+}
+
+func getOidcUsernameClaim() string {
+	return getConfigString("oidc.username_claim")
+}
+
+func getOidcAutoProvision() bool {
+	return getConfigBool("oidc.auto_provision")
+}
+
+func getOidcStateTTL() time.Duration {
+	return getConfigDuration("oidc.state_ttl")
+}
+
+func getOidcHTTPTimeout() time.Duration {
+	return getConfigDuration("oidc.http_timeout")
+}
@@ -0,0 +1,33 @@
+package config
+
+import "time"
+
+var (
+	// Return the minimum length of a password
+	GetPasswordMinLength = getPasswordMinLength
+
+	// Return true if a password must contain a digit
+	GetPasswordRequireDigit = getPasswordRequireDigit
+
+	// Return true if a password must contain upper and lower case letters
+	GetPasswordRequireMixedCase = getPasswordRequireMixedCase
+
+	// Return how long a password reset token is valid
+	GetPasswordResetTokenTTL = getPasswordResetTokenTTL
+)
+
+func getPasswordMinLength() int {
+	return getConfigInt("password.min_length")
+}
+
+func getPasswordRequireDigit() bool {
+	return getConfigBool("password.require_digit")
+}
+
+func getPasswordRequireMixedCase() bool {
+	return getConfigBool("password.require_mixed_case")
+}
+
+func getPasswordResetTokenTTL() time.Duration {
+	return getConfigDuration("password.reset_token_ttl")
+}
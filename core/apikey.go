@@ -0,0 +1,345 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Create an API key which is returned only once
+	CreateApiKey = createApiKey
+
+	// Returns all API keys without the keys themselves
+	GetApiKeys = getApiKeys
+
+	// Revoke an API key
+	RevokeApiKey = revokeApiKey
+)
+
+// Number of characters of a key which are stored to recognize it
+const apiKeyPrefixLength = 12
+
+func createApiKey(
+	ctx context.Context,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type createApiKeyRequest struct {
+		Name        string
+		Permissions []string
+		AllowedIPs  []string
+	}
+
+	request := &createApiKeyRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		cause := "Invalid value for name parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	principal, err := getPrincipal(ctx)
+	if err != nil {
+		return
+	}
+
+	permissions, err := validateApiKeyPermissions(
+		principal,
+		request.Permissions,
+	)
+	if err != nil {
+		return
+	}
+
+	allowedIPs, err := validateAllowedIPs(request.AllowedIPs)
+	if err != nil {
+		return
+	}
+
+	key, err := newApiKey()
+	if err != nil {
+		cause := "Failed to generate API key"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	var apiKey *data.ApiKeyInfo
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		apiKey, err = data.CreateApiKey(
+			ctx,
+			request.Name,
+			key,
+			key[:apiKeyPrefixLength],
+			permissions,
+			allowedIPs,
+			principal.UserId,
+		)
+		return err
+	})
+	if err != nil {
+		cause := "Failed to create API key"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	type createApiKeyResponse struct {
+		*data.ApiKeyInfo
+		Key string
+	}
+
+	response = &createApiKeyResponse{
+		ApiKeyInfo: apiKey,
+		Key:        key,
+	}
+
+	return
+}
+
+func getApiKeys(ctx context.Context) (response interface{}, err error) {
+	response, err = data.GetApiKeys(ctx)
+	if err != nil {
+		cause := "Failed to get API keys"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func revokeApiKey(ctx context.Context, keyId string) (err error) {
+	keyId = strings.TrimSpace(keyId)
+	if keyId == "" {
+		cause := "Invalid value for key id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	rowsAffected, err := data.RevokeApiKey(ctx, keyId)
+	if err != nil {
+		cause := "Failed to revoke API key"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if rowsAffected == 0 {
+		cause := "Active API key not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+// Resolve the principal of an API key. The key acts on behalf of the user
+// who created it with the permissions of the key.
+func authenticateApiKey(
+	ctx context.Context,
+	key string,
+) (response *Principal, err error) {
+	apiKey, err := data.UseApiKey(ctx, key)
+	if err != nil {
+		cause := "Failed to authorize API key"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if apiKey == nil {
+		cause := "API key is invalid or revoked"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	permissions := make(map[string]bool, len(apiKey.Permissions))
+	for _, permission := range apiKey.Permissions {
+		permissions[permission] = true
+	}
+
+	response = &Principal{
+		UserId:      apiKey.CreatedBy,
+		ApiKeyId:    apiKey.KeyId,
+		permissions: permissions,
+		allowedIPs:  apiKey.AllowedIPs,
+	}
+
+	return
+}
+
+// Return true if the client IP is in the allowlist of the principal. An
+// empty allowlist allows every IP.
+func isClientIPAllowed(principal *Principal, clientIP string) bool {
+	if len(principal.allowedIPs) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+
+	for _, allowed := range principal.allowedIPs {
+		_, network, err := net.ParseCIDR(allowed)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Keys can only be granted permissions which their creator has
+func validateApiKeyPermissions(
+	principal *Principal,
+	requested []string,
+) ([]string, error) {
+	unique := make(map[string]bool, len(requested))
+	permissions := make([]string, 0, len(requested))
+	for _, permission := range requested {
+		permission = strings.TrimSpace(permission)
+		if !knownPermissions[permission] {
+			cause := "Unknown permission " + permission
+			return nil, util.NewError(
+				cause,
+				util.ErrorCodeValidation,
+				util.ErrBadRequest,
+				nil,
+			)
+		}
+
+		if !principal.HasPermission(permission) {
+			cause := "API key can't be granted permission " + permission
+			return nil, util.NewError(
+				cause,
+				util.ErrorCodePermissionDenied,
+				util.ErrForbidden,
+				nil,
+			)
+		}
+
+		if !unique[permission] {
+			unique[permission] = true
+			permissions = append(permissions, permission)
+		}
+	}
+
+	if len(permissions) == 0 {
+		cause := "API key needs at least one permission"
+		return nil, util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			nil,
+		)
+	}
+
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// Validate the allowlist and store single IPs as networks
+func validateAllowedIPs(requested []string) ([]string, error) {
+	allowedIPs := make([]string, 0, len(requested))
+	for _, allowed := range requested {
+		allowed = strings.TrimSpace(allowed)
+
+		if ip := net.ParseIP(allowed); ip != nil {
+			if ip.To4() != nil {
+				allowed += "/32"
+			} else {
+				allowed += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(allowed)
+		if err != nil {
+			cause := "Invalid value for allowed IP " + allowed
+			return nil, util.NewError(
+				cause,
+				util.ErrorCodeValidation,
+				util.ErrBadRequest,
+				err,
+			)
+		}
+
+		allowedIPs = append(allowedIPs, network.String())
+	}
+
+	return allowedIPs, nil
+}
+
+func newApiKey() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return values.ApiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
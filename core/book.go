@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
 	"github.com/nordluma/go-bookstore/util"
 	"github.com/nordluma/go-bookstore/values"
 )
@@ -24,9 +25,6 @@ var (
 
 	UpdateBook = updateBook
 	DeleteBook = deleteBook
-
-	// Borrows a book if available or returns the book if it's borrowed
-	BorrowOrReturnBook = borrowOrReturnBook
 )
 
 func createBook(
@@ -157,32 +155,11 @@ func getAllBooks(
 	searchTerm string,
 	rowOffset, rowLimit, userRole int,
 ) (response interface{}, err error) {
-	if rowOffset < 0 {
-		cause := "Invalid value for row offset parameter"
-		err = util.NewError(
-			cause,
-			util.ErrorCodeValidation,
-			util.ErrBadRequest,
-			err,
-		)
-		return
-	}
-
-	if rowLimit < 0 || rowLimit > values.MaxRowLimit {
-		cause := "Invalid value for row limit parameter"
-		err = util.NewError(
-			cause,
-			util.ErrorCodeValidation,
-			util.ErrBadRequest,
-			err,
-		)
+	rowLimit, err = validatePaging(rowOffset, rowLimit)
+	if err != nil {
 		return
 	}
 
-	if rowLimit == 0 {
-		rowLimit = values.MaxRowLimit
-	}
-
 	var books interface{}
 	if userRole == values.UserRoleMember {
 		books, err = data.GetAllBooksForMember(
@@ -206,27 +183,7 @@ func getAllBooks(
 		return
 	}
 
-	type metaData struct {
-		SearchTerm string `json:",omitempty"`
-		RowOffset  int    `json:",omitempty"`
-		RowLimit   int
-	}
-
-	meta := &metaData{
-		SearchTerm: searchTerm,
-		RowOffset:  rowOffset,
-		RowLimit:   rowLimit,
-	}
-
-	type getAllResponse struct {
-		Data interface{} `json:"data"`
-		Meta interface{} `json:"meta"`
-	}
-
-	response = &getAllResponse{
-		Data: books,
-		Meta: meta,
-	}
+	response = newListResponse(books, searchTerm, rowOffset, rowLimit)
 
 	return
 }
@@ -358,139 +315,45 @@ func deleteBook(ctx context.Context, bookId string) (err error) {
 		return
 	}
 
-	rowsAffected, err := data.DeleteBook(ctx, bookId)
-	if err != nil {
-		cause := "Failed to delete book"
-		err = util.NewError(
-			cause,
-			util.ErrorCodeInternal,
-			util.ErrInternal,
-			err,
-		)
-		return
-	}
-
-	if rowsAffected == 0 {
-		cause := "Book not found"
-		err = util.NewError(
-			cause,
-			util.ErrorCodeEntityNotFound,
-			util.ErrResourceNotFound,
-			err,
-		)
-		return
-	}
-
-	return
-}
-
-func borrowOrReturnBook(
-	ctx context.Context,
-	token string,
-	requestBody io.Reader,
-) (err error) {
-	type borrowOrReturnBookRequest struct {
-		BookId string
-	}
-
-	request := &borrowOrReturnBookRequest{}
-	err = json.NewDecoder(requestBody).Decode(request)
-	if err != nil {
-		cause := "Failed to decode JSON"
-		err = util.NewError(
-			cause,
-			util.ErrorCodeValidation,
-			util.ErrBadRequest,
-			err,
-		)
-		return
-	}
-
-	request.BookId = strings.TrimSpace(request.BookId)
-	if request.BookId == "" {
-		cause := "Invalid value for book id parameter"
-		err = util.NewError(
-			cause,
-			util.ErrorCodeValidation,
-			util.ErrBadRequest,
-			err,
-		)
-		return
-	}
-
-	status, err := data.GetBookStatus(ctx, request.BookId)
-	if err != nil {
-		cause := "Failed to get book status"
-		err = util.NewError(
-			cause,
-			util.ErrorCodeInternal,
-			util.ErrInternal,
-			err,
-		)
-		return
-	}
-
-	if status == values.UserRoleUnknown {
-		cause := "Book not found"
-		err = util.NewError(
-			cause,
-			util.ErrorCodeEntityNotFound,
-			util.ErrResourceNotFound,
-			err,
-		)
-		return
-	}
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		// Locking the book keeps new loans and holds from being created
+		// meanwhile
+		lockedBookId, err := data.LockBook(ctx, bookId)
+		if err != nil {
+			return err
+		}
 
-	userId, err := data.GetUserId(ctx, token)
-	if err != nil {
-		cause := "Failed to get user id"
-		err = util.NewError(
-			cause,
-			util.ErrorCodeInternal,
-			util.ErrInternal,
-			err,
-		)
-		return
-	}
+		if lockedBookId == "" {
+			cause := "Book not found"
+			return util.NewError(
+				cause,
+				util.ErrorCodeEntityNotFound,
+				util.ErrResourceNotFound,
+				err,
+			)
+		}
 
-	newStatus := values.BookStatusAvailable
-	if status == values.BookStatusAvailable {
-		newStatus = values.BookStatusBorrowed
-	} else {
-		borrowerId := ""
-		borrowerId, err = data.GetBorrowerId(ctx, request.BookId)
+		// Loan history is kept, so books which have been lent can't be
+		// deleted
+		records, err := data.CountBookCirculationRecords(ctx, bookId)
 		if err != nil {
-			cause := "Failed to get borrower id"
-			err = util.NewError(cause, util.ErrorCodeInternal, util.ErrInternal, err)
-			return
+			return err
 		}
 
-		if borrowerId != userId {
-			cause := "Book not available"
-			err = util.NewError(cause, util.ErrorCodeEntityNotFound, util.ErrResourceNotFound, err)
-			return
+		if records > 0 {
+			cause := "Book has loans or holds"
+			return util.NewError(
+				cause,
+				util.ErrorCodeConflict,
+				util.ErrConflict,
+				err,
+			)
 		}
 
-		userId = ""
-		newStatus = values.BookStatusAvailable
-	}
+		_, err = data.DeleteBook(ctx, bookId)
+		return err
+	})
 
-	err = data.ChangeBookStatus(
-		ctx,
-		request.BookId,
-		newStatus,
-		util.NewNullableString(userId),
-	)
-	if err != nil {
-		cause := "Failed to change book status"
-		err = util.NewError(
-			cause,
-			util.ErrorCodeInternal,
-			util.ErrInternal,
-			err,
-		)
-		return
-	}
-
-	return
+	return wrapInternalError("Failed to delete book", err)
 }
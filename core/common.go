@@ -0,0 +1,105 @@
+package core
+
+import (
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+type listMetaData struct {
+	SearchTerm string `json:",omitempty"`
+	RowOffset  int    `json:",omitempty"`
+	RowLimit   int
+}
+
+type listResponse struct {
+	Data interface{} `json:"data"`
+	Meta interface{} `json:"meta"`
+}
+
+// Validate row offset and row limit parameters. Zero row limit is replaced
+// with the maximum row limit.
+func validatePaging(rowOffset, rowLimit int) (int, error) {
+	var err error
+	if rowOffset < 0 {
+		cause := "Invalid value for row offset parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return 0, err
+	}
+
+	if rowLimit < 0 || rowLimit > values.MaxRowLimit {
+		cause := "Invalid value for row limit parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return 0, err
+	}
+
+	if rowLimit == 0 {
+		rowLimit = values.MaxRowLimit
+	}
+
+	return rowLimit, nil
+}
+
+func newListResponse(
+	data interface{},
+	searchTerm string,
+	rowOffset, rowLimit int,
+) *listResponse {
+	return &listResponse{
+		Data: data,
+		Meta: &listMetaData{
+			SearchTerm: searchTerm,
+			RowOffset:  rowOffset,
+			RowLimit:   rowLimit,
+		},
+	}
+}
+
+// Wrap an error which is not already a server error, such as a failed
+// commit of a transaction, into an internal error
+func wrapInternalError(cause string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if isError, _, _, _ := util.IsError(err); isError {
+		return err
+	}
+
+	return util.NewError(cause, util.ErrorCodeInternal, util.ErrInternal, err)
+}
+
+// Returns true if the value is a uuid in its canonical form with hyphens,
+// such as the tokens of sessions
+func isValidUUID(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+
+	for i, c := range value {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			isHex := ('0' <= c && c <= '9') ||
+				('a' <= c && c <= 'f') ||
+				('A' <= c && c <= 'F')
+			if !isHex {
+				return false
+			}
+		}
+	}
+
+	return true
+}
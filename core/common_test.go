@@ -0,0 +1,22 @@
+package core
+
+import "testing"
+
+func TestIsValidUUID(t *testing.T) {
+	tests := map[string]bool{
+		"6f1c0a52-3b8e-4d2a-9f3e-2b7c1d0e4a5f":  true,
+		"6F1C0A52-3B8E-4D2A-9F3E-2B7C1D0E4A5F":  true,
+		"":                                      false,
+		"not-a-token":                           false,
+		"6f1c0a523b8e4d2a9f3e2b7c1d0e4a5f":      false,
+		"6f1c0a52-3b8e-4d2a-9f3e-2b7c1d0e4a5g":  false,
+		"6f1c0a52-3b8e-4d2a-9f3e_2b7c1d0e4a5f":  false,
+		"6f1c0a52-3b8e-4d2a-9f3e-2b7c1d0e4a5f'": false,
+	}
+
+	for value, expected := range tests {
+		if isValidUUID(value) != expected {
+			t.Errorf("Expected %v for %q", expected, value)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Create a new physical copy of a book
+	CreateCopy = createCopy
+
+	// Return a single copy
+	GetCopy = getCopy
+
+	// Returns a list of copies of a book
+	GetBookCopies = getBookCopies
+
+	UpdateCopy = updateCopy
+	DeleteCopy = deleteCopy
+)
+
+// Format of the acquisition date of a copy
+const acquiredAtLayout = "2006-01-02"
+
+func createCopy(
+	ctx context.Context,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type createCopyRequest struct {
+		BookId        string
+		Barcode       string
+		ShelfLocation string
+		Condition     string
+		AcquiredAt    string
+	}
+
+	request := &createCopyRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.BookId = strings.TrimSpace(request.BookId)
+	if request.BookId == "" {
+		cause := "Invalid value for book id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.Barcode = strings.TrimSpace(request.Barcode)
+	if request.Barcode == "" {
+		cause := "Trying to create a copy with empty barcode"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	acquiredAt := time.Now().UTC()
+	request.AcquiredAt = strings.TrimSpace(request.AcquiredAt)
+	if request.AcquiredAt != "" {
+		acquiredAt, err = time.Parse(acquiredAtLayout, request.AcquiredAt)
+		if err != nil {
+			cause := "Invalid value for acquisition date parameter"
+			err = util.NewError(
+				cause,
+				util.ErrorCodeValidation,
+				util.ErrBadRequest,
+				err,
+			)
+			return
+		}
+	}
+
+	book, err := data.GetBook(ctx, request.BookId)
+	if err != nil {
+		cause := "Failed to get book"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if book == nil {
+		cause := "Book not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return
+	}
+
+	var bookCopy *data.CopyEntity
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		var err error
+		bookCopy, err = data.CreateCopy(
+			ctx,
+			request.BookId,
+			request.Barcode,
+			util.NewNullableString(strings.TrimSpace(request.ShelfLocation)),
+			util.NewNullableString(strings.TrimSpace(request.Condition)),
+			acquiredAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		// A new copy goes straight to the members waiting for the book
+		bookCopy.Status, err = releaseCopy(
+			ctx,
+			bookCopy.CopyId,
+			bookCopy.BookId,
+		)
+		return err
+	})
+	if err != nil {
+		cause := "Failed to create copy"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	response = bookCopy
+	return
+}
+
+func getCopy(
+	ctx context.Context,
+	copyId string,
+) (response interface{}, err error) {
+	copyId = strings.TrimSpace(copyId)
+	if copyId == "" {
+		cause := "Invalid value for copy id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	bookCopy, err := data.GetCopy(ctx, copyId)
+	if err != nil {
+		cause := "Failed to get copy"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if bookCopy == nil {
+		cause := "Copy not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return
+	}
+
+	response = bookCopy
+	return
+}
+
+func getBookCopies(
+	ctx context.Context,
+	bookId string,
+	userRole int,
+) (response interface{}, err error) {
+	bookId = strings.TrimSpace(bookId)
+	if bookId == "" {
+		cause := "Invalid value for book id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	if userRole == values.UserRoleMember {
+		response, err = data.GetCopiesForMember(ctx, bookId)
+	} else {
+		response, err = data.GetCopiesForLibrarian(ctx, bookId)
+	}
+
+	if err != nil {
+		cause := "Failed to get copies of book"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func updateCopy(
+	ctx context.Context,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type updateCopyRequest struct {
+		CopyId        string
+		Barcode       string
+		ShelfLocation string
+		Condition     string
+	}
+
+	request := &updateCopyRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.CopyId = strings.TrimSpace(request.CopyId)
+	if request.CopyId == "" {
+		cause := "Invalid value for copy id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.Barcode = strings.TrimSpace(request.Barcode)
+	if request.Barcode == "" {
+		cause := "Invalid value for barcode parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	updatedAt, err := data.UpdateCopy(
+		ctx,
+		request.CopyId,
+		request.Barcode,
+		util.NewNullableString(strings.TrimSpace(request.ShelfLocation)),
+		util.NewNullableString(strings.TrimSpace(request.Condition)),
+	)
+	if err != nil {
+		cause := "Failed to update copy"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if updatedAt.IsZero() {
+		cause := "Copy not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return
+	}
+
+	type updateCopyResponse struct {
+		UpdatedAt time.Time
+	}
+
+	response = &updateCopyResponse{
+		UpdatedAt: updatedAt,
+	}
+
+	return
+}
+
+func deleteCopy(ctx context.Context, copyId string) (err error) {
+	copyId = strings.TrimSpace(copyId)
+	if copyId == "" {
+		cause := "Invalid value for copy id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		// Locking the copy keeps it from being lent meanwhile
+		_, err := lockCopy(ctx, copyId)
+		if err != nil {
+			return err
+		}
+
+		// Loan history is kept, so copies which have been lent can't be
+		// deleted
+		records, err := data.CountCopyCirculationRecords(ctx, copyId)
+		if err != nil {
+			return err
+		}
+
+		if records > 0 {
+			cause := "Copy has loans or holds"
+			return util.NewError(
+				cause,
+				util.ErrorCodeConflict,
+				util.ErrConflict,
+				err,
+			)
+		}
+
+		rowsAffected, err := data.DeleteCopy(ctx, copyId)
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			cause := "Copy is not available"
+			return util.NewError(
+				cause,
+				util.ErrorCodeConflict,
+				util.ErrConflict,
+				err,
+			)
+		}
+
+		return nil
+	})
+
+	return wrapInternalError("Failed to delete copy", err)
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/util"
+)
+
+func TestDeleteLentCopy(t *testing.T) {
+	prepareTestDb(t)
+
+	bookCopy := createTestCopy(t)
+	member := createTestMember(t, "deleter")
+
+	body := fmt.Sprintf(`{"CopyId": %q}`, bookCopy.CopyId)
+	response, err := BorrowBook(newTestContext(member), strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to borrow copy: %v", err)
+	}
+
+	loan := response.(*data.LoanEntity)
+	_, err = ReturnBook(newTestContext(member), loan.LoanId)
+	if err != nil {
+		t.Fatalf("Failed to return copy: %v", err)
+	}
+
+	// The returned loan is kept, so neither the copy nor its book can go
+	err = DeleteCopy(newTestContext(nil), bookCopy.CopyId)
+	expectConflict(t, err)
+
+	err = DeleteBook(newTestContext(nil), bookCopy.BookId)
+	expectConflict(t, err)
+}
+
+func expectConflict(t *testing.T, err error) {
+	t.Helper()
+
+	_, _, _, errorType := util.IsError(err)
+	if errorType != util.ErrConflict {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	testDbOnce sync.Once
+	testDbErr  error
+)
+
+// Connect to the database in DATABASE_URL, which must have the schema and
+// all migrations applied. Tests which need a database are skipped without it.
+// The test workflow in .github/workflows prepares such a database, and shows
+// how to prepare one locally.
+func prepareTestDb(t *testing.T) {
+	t.Helper()
+
+	connectionString := os.Getenv("DATABASE_URL")
+	if connectionString == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	testDbOnce.Do(func() {
+		testDbErr = config.InitConfig("bookstore", []string{".."})
+		if testDbErr != nil {
+			return
+		}
+
+		viper.Set("database.connection_string", connectionString)
+		testDbErr = dbserver.InitializeDb()
+	})
+	if testDbErr != nil {
+		t.Fatalf("Failed to prepare database: %v", testDbErr)
+	}
+}
+
+// Return a context with its own db runner, authenticated as the user if one
+// is given
+func newTestContext(user *data.UserInfo) context.Context {
+	ctx := dbserver.PrepareDbRunner(context.Background())
+	if user == nil {
+		return ctx
+	}
+
+	return WithPrincipal(ctx, &Principal{
+		UserId:   user.UserId,
+		UserRole: int(user.UserRole),
+	})
+}
+
+// Execute a statement which the data layer has no function for, such as
+// removing test data
+func execTestQuery(t *testing.T, query string, params ...interface{}) {
+	t.Helper()
+
+	ctx := newTestContext(nil)
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	_, err := dbRunner.Exec(ctx, query, params...)
+	if err != nil {
+		t.Fatalf("Failed to execute %q: %v", query, err)
+	}
+}
+
+// Create an active member which is deleted with its loans and fines when
+// the test ends
+func createTestMember(t *testing.T, name string) *data.UserInfo {
+	t.Helper()
+
+	return createTestUser(t, name, values.UserRoleMember)
+}
+
+// Create an active user with the role, which is deleted with its loans and
+// fines when the test ends
+func createTestUser(t *testing.T, name string, userRole int) *data.UserInfo {
+	t.Helper()
+
+	username := fmt.Sprintf("%v-%d@example.com", name, time.Now().UnixNano())
+	user, err := data.CreateUser(
+		newTestContext(nil),
+		username,
+		"Password1",
+		name,
+		userRole,
+		util.NullString{},
+		nil,
+	)
+	if err != nil || user == nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	t.Cleanup(func() { deleteTestUser(t, user.UserId) })
+
+	return user
+}
+
+func deleteTestUser(t *testing.T, userId string) {
+	t.Helper()
+
+	execTestQuery(t, "DELETE FROM fine_entry WHERE member_id = $1", userId)
+	execTestQuery(t, "DELETE FROM loan WHERE member_id = $1", userId)
+	execTestQuery(t, "DELETE FROM library_user WHERE user_id = $1", userId)
+}
+
+// Create a book with a single available copy which are deleted when the
+// test ends
+func createTestCopy(t *testing.T) *data.CopyEntity {
+	t.Helper()
+
+	ctx := newTestContext(nil)
+	book, err := data.CreateBook(
+		ctx,
+		"Test book",
+		"Test author",
+		"Test publisher",
+		util.NullString{},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+
+	barcode := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	bookCopy, err := data.CreateCopy(
+		ctx,
+		book.BookId,
+		barcode,
+		util.NullString{},
+		util.NullString{},
+		time.Now(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create copy: %v", err)
+	}
+
+	// Registered before the members are created, so that it runs after
+	// their loans are deleted
+	t.Cleanup(func() {
+		execTestQuery(t, "DELETE FROM loan WHERE copy_id = $1", bookCopy.CopyId)
+		execTestQuery(
+			t,
+			"DELETE FROM book_copy WHERE copy_id = $1",
+			bookCopy.CopyId,
+		)
+		execTestQuery(t, "DELETE FROM book WHERE book_id = $1", book.BookId)
+	})
+
+	return bookCopy
+}
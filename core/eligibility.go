@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/util"
+)
+
+// Check that the member is allowed to borrow another copy. The member row
+// stays locked until the surrounding transaction ends so concurrent borrows
+// of the same member are evaluated one after another. Must be called within a
+// transaction.
+func checkBorrowingEligibility(
+	ctx context.Context,
+	memberId string,
+) (err error) {
+	status, err := data.LockBorrowingStatus(ctx, memberId)
+	if err != nil {
+		cause := "Failed to get borrowing status"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if status == nil {
+		cause := "User not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return
+	}
+
+	if status.MembershipExpiresAt != nil &&
+		status.MembershipExpiresAt.Before(time.Now()) {
+		cause := "Membership has expired"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeMembershipExpired,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	if status.OverdueLoans > 0 && config.GetCirculationBlockOnOverdue() {
+		cause := "Overdue books must be returned before borrowing"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeOverdueLoans,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	maxLoans := config.GetCirculationMaxLoans(
+		status.RoleName,
+		status.MemberCategory,
+	)
+	if maxLoans > 0 && status.OpenLoans >= int64(maxLoans) {
+		cause := "Maximum number of loans reached"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeLoanLimitReached,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	return checkFineBalance(ctx, memberId)
+}
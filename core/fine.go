@@ -0,0 +1,309 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Returns the fine balance and ledger of the member
+	GetMemberFines = getMemberFines
+
+	// Returns the fine balance and ledger of any member
+	GetFines = getFines
+
+	// Record a payment towards the fines of a member
+	RecordFinePayment = recordFinePayment
+
+	// Waive fines of a member
+	WaiveFine = waiveFine
+)
+
+type fineLedgerResponse struct {
+	Balance int64
+	Data    interface{} `json:"data"`
+	Meta    interface{} `json:"meta"`
+}
+
+func getMemberFines(
+	ctx context.Context,
+	rowOffset, rowLimit int,
+) (response interface{}, err error) {
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	return getFineLedger(ctx, userId, rowOffset, rowLimit)
+}
+
+func getFines(
+	ctx context.Context,
+	memberId string,
+	rowOffset, rowLimit int,
+) (response interface{}, err error) {
+	memberId = strings.TrimSpace(memberId)
+	if memberId == "" {
+		cause := "Invalid value for member id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	return getFineLedger(ctx, memberId, rowOffset, rowLimit)
+}
+
+func getFineLedger(
+	ctx context.Context,
+	memberId string,
+	rowOffset, rowLimit int,
+) (response interface{}, err error) {
+	rowLimit, err = validatePaging(rowOffset, rowLimit)
+	if err != nil {
+		return
+	}
+
+	balance, err := data.GetFineBalance(ctx, memberId)
+	if err != nil {
+		cause := "Failed to get fine balance"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	entries, err := data.GetFineEntries(ctx, memberId, rowOffset, rowLimit)
+	if err != nil {
+		cause := "Failed to get fines"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	list := newListResponse(entries, "", rowOffset, rowLimit)
+	response = &fineLedgerResponse{
+		Balance: balance,
+		Data:    list.Data,
+		Meta:    list.Meta,
+	}
+
+	return
+}
+
+func recordFinePayment(
+	ctx context.Context,
+	memberId string,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	return createFineCredit(
+		ctx,
+		memberId,
+		requestBody,
+		values.FineEntryTypePayment,
+	)
+}
+
+func waiveFine(
+	ctx context.Context,
+	memberId string,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	return createFineCredit(
+		ctx,
+		memberId,
+		requestBody,
+		values.FineEntryTypeWaiver,
+	)
+}
+
+// Reduce the fine balance of a member with a payment or a waiver
+func createFineCredit(
+	ctx context.Context,
+	memberId string,
+	requestBody io.Reader,
+	entryType int,
+) (response interface{}, err error) {
+	type fineCreditRequest struct {
+		Amount int64
+		Note   string
+	}
+
+	memberId = strings.TrimSpace(memberId)
+	if memberId == "" {
+		cause := "Invalid value for member id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request := &fineCreditRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	if request.Amount <= 0 {
+		cause := "Invalid value for amount parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	// The member is locked so that concurrent payments and waivers can't
+	// both pass the balance check
+	var entry *data.FineEntryEntity
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		lockedId, err := data.LockUser(ctx, memberId)
+		if err != nil {
+			return err
+		}
+
+		if lockedId == "" {
+			cause := "Member not found"
+			return util.NewError(
+				cause,
+				util.ErrorCodeEntityNotFound,
+				util.ErrResourceNotFound,
+				err,
+			)
+		}
+
+		balance, err := data.GetFineBalance(ctx, memberId)
+		if err != nil {
+			return err
+		}
+
+		if request.Amount > balance {
+			cause := "Amount exceeds the outstanding balance"
+			return util.NewError(
+				cause,
+				util.ErrorCodeValidation,
+				util.ErrBadRequest,
+				err,
+			)
+		}
+
+		entry, err = data.CreateFineEntry(
+			ctx,
+			memberId,
+			util.NullString{},
+			entryType,
+			request.Amount,
+			util.NewNullableString(strings.TrimSpace(request.Note)),
+			util.NewNullableString(userId),
+		)
+		return err
+	})
+	if err != nil {
+		err = wrapInternalError("Failed to record fine", err)
+		return
+	}
+
+	response = entry
+	return
+}
+
+// Charge the member for a loan which was returned after its due date. Must be
+// called within a transaction.
+func accrueFine(
+	ctx context.Context,
+	loan *data.LoanEntity,
+	returnedAt time.Time,
+) error {
+	if !returnedAt.After(loan.DueAt) {
+		return nil
+	}
+
+	daysLate := int64(math.Ceil(returnedAt.Sub(loan.DueAt).Hours() / 24))
+	amount := daysLate * config.GetFineDailyRate()
+	if maxAmount := config.GetFineMaxAmount(); maxAmount > 0 &&
+		amount > maxAmount {
+		amount = maxAmount
+	}
+
+	if amount <= 0 {
+		return nil
+	}
+
+	_, err := data.CreateFineEntry(
+		ctx,
+		loan.MemberId,
+		util.NewNullableString(loan.LoanId),
+		values.FineEntryTypeCharge,
+		amount,
+		util.NewNullableString(fmt.Sprintf("Returned %d days late", daysLate)),
+		util.NullString{},
+	)
+	return err
+}
+
+// Refuse borrowing from members whose fine balance is above the threshold
+func checkFineBalance(ctx context.Context, memberId string) (err error) {
+	balance, err := data.GetFineBalance(ctx, memberId)
+	if err != nil {
+		cause := "Failed to get fine balance"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if balance > config.GetFineBorrowBlockThreshold() {
+		cause := "Outstanding fines must be paid before borrowing"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeFineBalanceExceeded,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	return
+}
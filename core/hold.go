@@ -0,0 +1,369 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Place the member into the hold queue of a book
+	PlaceHold = placeHold
+
+	// Returns a list of the member's holds
+	GetMemberHolds = getMemberHolds
+
+	// Cancel a waiting or ready hold of the member
+	CancelHold = cancelHold
+)
+
+func placeHold(
+	ctx context.Context,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type placeHoldRequest struct {
+		BookId string
+	}
+
+	request := &placeHoldRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.BookId = strings.TrimSpace(request.BookId)
+	if request.BookId == "" {
+		cause := "Invalid value for book id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	book, err := data.GetBook(ctx, request.BookId)
+	if err != nil {
+		cause := "Failed to get book"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if book == nil {
+		cause := "Book not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return
+	}
+
+	availableCopies, err := data.CountAvailableCopies(ctx, request.BookId)
+	if err != nil {
+		cause := "Failed to count available copies"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if availableCopies > 0 {
+		cause := "Book has available copies"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	activeHold, err := data.GetActiveHold(ctx, request.BookId, userId)
+	if err != nil {
+		cause := "Failed to get hold"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if activeHold != nil {
+		cause := "Book is already on hold"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	response, err = data.CreateHold(ctx, request.BookId, userId)
+	if err != nil {
+		cause := "Failed to place hold"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func getMemberHolds(ctx context.Context) (response interface{}, err error) {
+	err = expireHolds(ctx)
+	if err != nil {
+		return
+	}
+
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	response, err = data.GetHoldsForMember(ctx, userId)
+	if err != nil {
+		cause := "Failed to get holds"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func cancelHold(ctx context.Context, holdId string) (err error) {
+	holdId = strings.TrimSpace(holdId)
+	if holdId == "" {
+		cause := "Invalid value for hold id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		hold, err := lockHoldCopy(ctx, holdId)
+		if err != nil {
+			return err
+		}
+
+		if hold == nil || hold.MemberId != userId {
+			cause := "Hold not found"
+			return util.NewError(
+				cause,
+				util.ErrorCodeEntityNotFound,
+				util.ErrResourceNotFound,
+				err,
+			)
+		}
+
+		if hold.Status != values.HoldStatusWaiting &&
+			hold.Status != values.HoldStatusReady {
+			cause := "Hold is not active"
+			return util.NewError(
+				cause,
+				util.ErrorCodeValidation,
+				util.ErrBadRequest,
+				err,
+			)
+		}
+
+		rowsAffected, err := data.CloseHold(
+			ctx,
+			hold.HoldId,
+			hold.Status,
+			values.HoldStatusCancelled,
+		)
+		if err != nil {
+			return err
+		}
+
+		// A waiting hold may have been given a copy meanwhile
+		if rowsAffected == 0 {
+			return newHoldChangedError()
+		}
+
+		if hold.Status != values.HoldStatusReady {
+			return nil
+		}
+
+		_, err = releaseCopy(ctx, hold.CopyId, hold.BookId)
+		return err
+	})
+
+	return wrapInternalError("Failed to cancel hold", err)
+}
+
+// Lock the copy which is reserved for the hold and return the hold. Copies
+// are locked before holds everywhere, so the hold is read again until its
+// copy is the locked one. Must be called within a transaction.
+func lockHoldCopy(
+	ctx context.Context,
+	holdId string,
+) (hold *data.HoldEntity, err error) {
+	lockedCopyId := ""
+	for {
+		hold, err = data.GetHold(ctx, holdId)
+		if err != nil || hold == nil {
+			return
+		}
+
+		if hold.CopyId == "" || hold.CopyId == lockedCopyId {
+			return
+		}
+
+		// The copy may have been deleted, which unlinks it from the hold
+		_, err = data.LockCopy(ctx, hold.CopyId)
+		if err != nil {
+			return
+		}
+
+		lockedCopyId = hold.CopyId
+	}
+}
+
+func newHoldChangedError() error {
+	cause := "Hold was changed by another request"
+	return util.NewError(cause, util.ErrorCodeConflict, util.ErrConflict, nil)
+}
+
+// Reserve the copy for the first member in the hold queue of the book, or
+// make it available if nobody is waiting. Returns the new status of the
+// copy. Must be called within a transaction which holds the lock of the
+// copy.
+func releaseCopy(
+	ctx context.Context,
+	copyId, bookId string,
+) (status int, err error) {
+	if copyId == "" {
+		return values.BookStatusUnknown, nil
+	}
+
+	hold, err := data.GetNextWaitingHold(ctx, bookId)
+	if err != nil {
+		return
+	}
+
+	if hold == nil {
+		status = values.BookStatusAvailable
+		err = data.ChangeCopyStatus(ctx, copyId, status)
+		return
+	}
+
+	expiresAt := time.Now().Add(config.GetCirculationHoldPickupPeriod())
+	err = data.ReadyHold(ctx, hold.HoldId, copyId, expiresAt)
+	if err != nil {
+		return
+	}
+
+	status = values.BookStatusReserved
+	err = data.ChangeCopyStatus(ctx, copyId, status)
+
+	return
+}
+
+// Expire ready holds which were not picked up in time and pass their copies
+// on to the next member in the queue. Every hold is expired in its own
+// transaction, which locks the copy before the hold like borrowing and
+// returning do.
+func expireHolds(ctx context.Context) (err error) {
+	holds, err := data.GetExpiredHolds(ctx)
+	for i := 0; err == nil && i < len(holds); i++ {
+		err = expireHold(ctx, holds[i].HoldId)
+	}
+
+	if err != nil {
+		cause := "Failed to expire holds"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func expireHold(ctx context.Context, holdId string) error {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	return dbRunner.Transact(ctx, nil, func() error {
+		hold, err := lockHoldCopy(ctx, holdId)
+		if err != nil || hold == nil {
+			return err
+		}
+
+		// Another request may have expired the hold, or the member picked
+		// it up, while this one waited for the lock
+		isExpired := hold.ExpiresAt != nil && hold.ExpiresAt.Before(time.Now())
+		if hold.Status != values.HoldStatusReady || !isExpired {
+			return nil
+		}
+
+		rowsAffected, err := data.CloseHold(
+			ctx,
+			hold.HoldId,
+			values.HoldStatusReady,
+			values.HoldStatusExpired,
+		)
+		if err != nil || rowsAffected == 0 {
+			return err
+		}
+
+		_, err = releaseCopy(ctx, hold.CopyId, hold.BookId)
+		return err
+	})
+}
@@ -0,0 +1,946 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Borrow a copy for the member. Borrowing a copy the member already has
+	// returns the existing loan.
+	BorrowBook = borrowBook
+
+	// Return a loan of the member. Returning a loan which is already
+	// returned is a no-op.
+	ReturnBook = returnBook
+
+	// Check out a copy by its barcode on behalf of a member
+	CheckOutCopy = checkOutCopy
+
+	// Check in a copy by its barcode. Checking in a copy which is not on
+	// loan is a no-op.
+	CheckInCopy = checkInCopy
+
+	// Returns the loan history of a book
+	GetBookLoans = getBookLoans
+
+	// Returns a list of loans which are past their due date
+	GetOverdueLoans = getOverdueLoans
+
+	// Extends the due date of an open loan
+	RenewLoan = renewLoan
+
+	// Returns the current loans of the member
+	GetMemberLoans = getMemberLoans
+
+	// Returns the past loans of the member
+	GetMemberLoanHistory = getMemberLoanHistory
+
+	// Returns the current loans of any member
+	GetLoans = getLoans
+
+	// Returns the past loans of any member
+	GetLoanHistory = getLoanHistory
+)
+
+// Lock the copy for the rest of the transaction and return it. Must be
+// called within a transaction.
+func lockCopy(
+	ctx context.Context,
+	copyId string,
+) (bookCopy *data.CopyEntity, err error) {
+	bookCopy, err = data.LockCopy(ctx, copyId)
+	return checkLockedCopy(bookCopy, err)
+}
+
+// Lock the copy with the barcode for the rest of the transaction and return
+// it. Must be called within a transaction.
+func lockCopyByBarcode(
+	ctx context.Context,
+	barcode string,
+) (bookCopy *data.CopyEntity, err error) {
+	bookCopy, err = data.LockCopyByBarcode(ctx, barcode)
+	return checkLockedCopy(bookCopy, err)
+}
+
+func checkLockedCopy(
+	bookCopy *data.CopyEntity,
+	err error,
+) (*data.CopyEntity, error) {
+	if err != nil {
+		cause := "Failed to get copy"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return nil, err
+	}
+
+	if bookCopy == nil {
+		cause := "Copy not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return nil, err
+	}
+
+	return bookCopy, nil
+}
+
+// Return the hold the reserved copy is waiting for if it belongs to the
+// member
+func getReservingHold(
+	ctx context.Context,
+	bookCopy *data.CopyEntity,
+	memberId string,
+) (hold *data.HoldEntity, err error) {
+	hold, err = data.GetReadyHoldForCopy(ctx, bookCopy.CopyId)
+	if err != nil {
+		cause := "Failed to get hold"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if hold == nil || hold.MemberId != memberId {
+		cause := "Book is reserved for another member"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeConflict,
+			util.ErrConflict,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+// Change the status of the copy, failing with a conflict if another request
+// changed it first
+func swapCopyStatus(
+	ctx context.Context,
+	bookCopy *data.CopyEntity,
+	status int,
+) (err error) {
+	rowsAffected, err := data.SwapCopyStatus(
+		ctx,
+		bookCopy.CopyId,
+		bookCopy.Status,
+		status,
+	)
+	if err != nil {
+		cause := "Failed to change copy status"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if rowsAffected == 0 {
+		cause := "Copy was changed by another request"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeConflict,
+			util.ErrConflict,
+			err,
+		)
+		return
+	}
+
+	bookCopy.Status = status
+	return
+}
+
+// Lend the copy to the member. Must be called within a transaction which
+// holds the lock of the copy.
+func lendCopy(
+	ctx context.Context,
+	bookCopy *data.CopyEntity,
+	memberId string,
+	hold *data.HoldEntity,
+) (loan *data.LoanEntity, err error) {
+	err = checkBorrowingEligibility(ctx, memberId)
+	if err != nil {
+		return
+	}
+
+	err = swapCopyStatus(ctx, bookCopy, values.BookStatusBorrowed)
+	if err != nil {
+		return
+	}
+
+	dueAt := time.Now().Add(config.GetCirculationLoanPeriod())
+	loan, err = data.CreateLoan(
+		ctx,
+		bookCopy.CopyId,
+		bookCopy.BookId,
+		memberId,
+		dueAt,
+	)
+	if err != nil {
+		cause := "Failed to create loan"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if hold == nil {
+		return
+	}
+
+	rowsAffected, err := data.CloseHold(
+		ctx,
+		hold.HoldId,
+		values.HoldStatusReady,
+		values.HoldStatusFulfilled,
+	)
+	if err != nil {
+		cause := "Failed to fulfill hold"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if rowsAffected == 0 {
+		err = newHoldChangedError()
+		return
+	}
+
+	return
+}
+
+// Close the loan and pass the copy on to the hold queue. Must be called
+// within a transaction which holds the lock of the copy.
+func closeLoanOfCopy(
+	ctx context.Context,
+	bookCopy *data.CopyEntity,
+	loan *data.LoanEntity,
+) (err error) {
+	returnedAt, err := data.CloseLoan(ctx, loan.LoanId)
+	if err != nil {
+		cause := "Failed to close loan"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if returnedAt.IsZero() {
+		cause := "Loan was returned by another request"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeConflict,
+			util.ErrConflict,
+			err,
+		)
+		return
+	}
+
+	err = accrueFine(ctx, loan, returnedAt)
+	if err != nil {
+		cause := "Failed to charge fine"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	_, err = releaseCopy(ctx, bookCopy.CopyId, bookCopy.BookId)
+	if err != nil {
+		cause := "Failed to release copy"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	loan.ReturnedAt = &returnedAt
+	return
+}
+
+// Lend the copy to the member unless the member has it already. Must be
+// called within a transaction which holds the lock of the copy.
+func checkOutLockedCopy(
+	ctx context.Context,
+	bookCopy *data.CopyEntity,
+	memberId string,
+) (loan *data.LoanEntity, err error) {
+	switch bookCopy.Status {
+	case values.BookStatusAvailable:
+		return lendCopy(ctx, bookCopy, memberId, nil)
+	case values.BookStatusReserved:
+		var hold *data.HoldEntity
+		hold, err = getReservingHold(ctx, bookCopy, memberId)
+		if err != nil {
+			return
+		}
+
+		return lendCopy(ctx, bookCopy, memberId, hold)
+	}
+
+	loan, err = data.GetOpenLoanForCopy(ctx, bookCopy.CopyId)
+	if err != nil {
+		cause := "Failed to get loan"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	// A repeated request gets the loan created by the first one
+	if loan == nil || loan.MemberId != memberId {
+		cause := "Book not available, place a hold to join the queue"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeConflict,
+			util.ErrConflict,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func borrowBook(
+	ctx context.Context,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type borrowBookRequest struct {
+		CopyId string
+	}
+
+	request := &borrowBookRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.CopyId = strings.TrimSpace(request.CopyId)
+	if request.CopyId == "" {
+		cause := "Invalid value for copy id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	err = expireHolds(ctx)
+	if err != nil {
+		return
+	}
+
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	// The copy stays locked until the transaction ends so concurrent
+	// requests for the same copy are processed one after another
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		bookCopy, err := lockCopy(ctx, request.CopyId)
+		if err != nil {
+			return err
+		}
+
+		loan, err := checkOutLockedCopy(ctx, bookCopy, userId)
+		if err != nil {
+			return err
+		}
+
+		response = loan
+		return nil
+	})
+
+	err = wrapInternalError("Failed to borrow book", err)
+	return
+}
+
+func returnBook(
+	ctx context.Context,
+	loanId string,
+) (response interface{}, err error) {
+	loanId = strings.TrimSpace(loanId)
+	if loanId == "" {
+		cause := "Invalid value for loan id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	loan, err := data.GetLoan(ctx, loanId)
+	if err != nil {
+		cause := "Failed to get loan"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if loan == nil || loan.MemberId != userId {
+		cause := "Loan not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return
+	}
+
+	// A repeated request gets the loan returned by the first one
+	if loan.ReturnedAt != nil {
+		response = loan
+		return
+	}
+
+	// The copy is locked before the loan, in the same order as when
+	// borrowing, so the two can't deadlock
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		bookCopy, err := lockCopy(ctx, loan.CopyId)
+		if err != nil {
+			return err
+		}
+
+		loan, err = data.LockLoan(ctx, loanId)
+		if err != nil {
+			return err
+		}
+
+		// The loan may have been deleted since it was read
+		if loan == nil {
+			cause := "Loan not found"
+			return util.NewError(
+				cause,
+				util.ErrorCodeEntityNotFound,
+				util.ErrResourceNotFound,
+				err,
+			)
+		}
+
+		if loan.ReturnedAt == nil {
+			err = closeLoanOfCopy(ctx, bookCopy, loan)
+			if err != nil {
+				return err
+			}
+		}
+
+		response = loan
+		return nil
+	})
+
+	err = wrapInternalError("Failed to return book", err)
+	return
+}
+
+func checkOutCopy(
+	ctx context.Context,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type checkOutCopyRequest struct {
+		Barcode  string
+		MemberId string
+	}
+
+	request := &checkOutCopyRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.Barcode = strings.TrimSpace(request.Barcode)
+	if request.Barcode == "" {
+		cause := "Invalid value for barcode parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.MemberId = strings.TrimSpace(request.MemberId)
+	if request.MemberId == "" {
+		cause := "Invalid value for member id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	err = expireHolds(ctx)
+	if err != nil {
+		return
+	}
+
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		bookCopy, err := lockCopyByBarcode(ctx, request.Barcode)
+		if err != nil {
+			return err
+		}
+
+		loan, err := checkOutLockedCopy(ctx, bookCopy, request.MemberId)
+		if err != nil {
+			return err
+		}
+
+		response = loan
+		return nil
+	})
+
+	err = wrapInternalError("Failed to check out copy", err)
+	return
+}
+
+func checkInCopy(
+	ctx context.Context,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type checkInCopyRequest struct {
+		Barcode string
+	}
+
+	request := &checkInCopyRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.Barcode = strings.TrimSpace(request.Barcode)
+	if request.Barcode == "" {
+		cause := "Invalid value for barcode parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		bookCopy, err := lockCopyByBarcode(ctx, request.Barcode)
+		if err != nil {
+			return err
+		}
+
+		loan, err := data.GetOpenLoanForCopy(ctx, bookCopy.CopyId)
+		if err != nil {
+			return err
+		}
+
+		// A repeated request gets the loan returned by the first one
+		if loan == nil {
+			loan, err = data.GetLatestLoanForCopy(ctx, bookCopy.CopyId)
+			if err != nil {
+				return err
+			}
+
+			if loan == nil {
+				cause := "Copy is not on loan"
+				return util.NewError(
+					cause,
+					util.ErrorCodeEntityNotFound,
+					util.ErrResourceNotFound,
+					nil,
+				)
+			}
+
+			response = loan
+			return nil
+		}
+
+		err = closeLoanOfCopy(ctx, bookCopy, loan)
+		if err != nil {
+			return err
+		}
+
+		response = loan
+		return nil
+	})
+
+	err = wrapInternalError("Failed to check in copy", err)
+	return
+}
+
+func getBookLoans(
+	ctx context.Context,
+	bookId string,
+	rowOffset, rowLimit int,
+) (response interface{}, err error) {
+	bookId = strings.TrimSpace(bookId)
+	if bookId == "" {
+		cause := "Invalid value for book id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	rowLimit, err = validatePaging(rowOffset, rowLimit)
+	if err != nil {
+		return
+	}
+
+	loans, err := data.GetLoansForBook(ctx, bookId, rowOffset, rowLimit)
+	if err != nil {
+		cause := "Failed to get loans of book"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	response = newListResponse(loans, "", rowOffset, rowLimit)
+	return
+}
+
+func getOverdueLoans(
+	ctx context.Context,
+	rowOffset, rowLimit int,
+) (response interface{}, err error) {
+	rowLimit, err = validatePaging(rowOffset, rowLimit)
+	if err != nil {
+		return
+	}
+
+	loans, err := data.GetOverdueLoans(ctx, rowOffset, rowLimit)
+	if err != nil {
+		cause := "Failed to get overdue loans"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	response = newListResponse(loans, "", rowOffset, rowLimit)
+	return
+}
+
+func renewLoan(
+	ctx context.Context,
+	loanId string,
+	userRole int,
+) (response interface{}, err error) {
+	loanId = strings.TrimSpace(loanId)
+	if loanId == "" {
+		cause := "Invalid value for loan id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		loan, err := renewLockedLoan(ctx, loanId, userId, userRole)
+		if err != nil {
+			return err
+		}
+
+		response = loan
+		return nil
+	})
+
+	err = wrapInternalError("Failed to renew loan", err)
+	return
+}
+
+// Must be called within a transaction
+func renewLockedLoan(
+	ctx context.Context,
+	loanId, userId string,
+	userRole int,
+) (loan *data.LoanEntity, err error) {
+	loan, err = data.LockLoan(ctx, loanId)
+	if err != nil {
+		cause := "Failed to get loan"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	// Members can only renew their own loans
+	if loan == nil ||
+		(userRole == values.UserRoleMember && loan.MemberId != userId) {
+		cause := "Loan not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return
+	}
+
+	if loan.ReturnedAt != nil {
+		cause := "Loan is already returned"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	if loan.RenewalCount >= config.GetCirculationMaxRenewals() {
+		cause := "Loan has reached the renewal limit"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeRenewalLimitReached,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	waitingHolds, err := data.CountWaitingHolds(ctx, loan.BookId)
+	if err != nil {
+		cause := "Failed to count holds"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if waitingHolds > 0 {
+		cause := "Book has pending holds"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeTitleOnHold,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	// Overdue loans are renewed starting from today
+	renewFrom := loan.DueAt
+	if now := time.Now(); now.After(renewFrom) {
+		renewFrom = now
+	}
+
+	newDueAt := renewFrom.Add(config.GetCirculationRenewalPeriod())
+	err = data.RenewLoan(ctx, loan.LoanId, userId, loan.DueAt, newDueAt)
+	if err != nil {
+		cause := "Failed to renew loan"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	loan.DueAt = newDueAt
+	loan.RenewalCount++
+
+	return
+}
+
+func getMemberLoans(ctx context.Context) (response interface{}, err error) {
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	return getLoans(ctx, userId)
+}
+
+func getMemberLoanHistory(
+	ctx context.Context,
+	rowOffset, rowLimit int,
+) (response interface{}, err error) {
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	return getLoanHistory(ctx, userId, rowOffset, rowLimit)
+}
+
+func getLoans(
+	ctx context.Context,
+	memberId string,
+) (response interface{}, err error) {
+	memberId = strings.TrimSpace(memberId)
+	if memberId == "" {
+		cause := "Invalid value for member id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	response, err = data.GetOpenLoansForMember(ctx, memberId)
+	if err != nil {
+		cause := "Failed to get loans"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func getLoanHistory(
+	ctx context.Context,
+	memberId string,
+	rowOffset, rowLimit int,
+) (response interface{}, err error) {
+	memberId = strings.TrimSpace(memberId)
+	if memberId == "" {
+		cause := "Invalid value for member id parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	rowLimit, err = validatePaging(rowOffset, rowLimit)
+	if err != nil {
+		return
+	}
+
+	loans, err := data.GetLoanHistoryForMember(
+		ctx,
+		memberId,
+		rowOffset,
+		rowLimit,
+	)
+	if err != nil {
+		cause := "Failed to get loan history"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	response = newListResponse(loans, "", rowOffset, rowLimit)
+	return
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+func TestBorrowBookConcurrently(t *testing.T) {
+	prepareTestDb(t)
+
+	const borrowers = 16
+
+	bookCopy := createTestCopy(t)
+	members := make([]*data.UserInfo, borrowers)
+	for i := range members {
+		members[i] = createTestMember(t, fmt.Sprintf("borrower-%d", i))
+	}
+
+	// All members ask for the copy at the same time
+	start := make(chan struct{})
+	errs := make([]error, borrowers)
+
+	var wg sync.WaitGroup
+	for i, member := range members {
+		wg.Add(1)
+		go func(i int, member *data.UserInfo) {
+			defer wg.Done()
+
+			ctx := newTestContext(member)
+			body := fmt.Sprintf(`{"CopyId": %q}`, bookCopy.CopyId)
+			<-start
+
+			_, errs[i] = BorrowBook(ctx, strings.NewReader(body))
+		}(i, member)
+	}
+
+	close(start)
+	wg.Wait()
+
+	successes := 0
+	for i, err := range errs {
+		if err == nil {
+			successes++
+			continue
+		}
+
+		_, _, _, errorType := util.IsError(err)
+		status := util.MapErrorTypeToHTTPStatus(errorType)
+		if errorType != util.ErrConflict || status != 409 {
+			t.Errorf("Borrower %d: expected a conflict, got %v", i, err)
+		}
+	}
+
+	if successes != 1 {
+		t.Errorf("Expected exactly one loan to succeed, got %d", successes)
+	}
+
+	ctx := newTestContext(nil)
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	var openLoans int
+	err := dbRunner.QueryRow(
+		ctx,
+		"SELECT COUNT(*) FROM loan WHERE copy_id = $1 AND returned_at IS NULL",
+		bookCopy.CopyId,
+	).Scan(&openLoans)
+	if err != nil {
+		t.Fatalf("Failed to count open loans: %v", err)
+	}
+
+	if openLoans != 1 {
+		t.Errorf("Expected one open loan of the copy, got %d", openLoans)
+	}
+}
@@ -0,0 +1,354 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/oidc"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Start a login through the identity provider and return the URL which
+	// the user is sent to
+	StartOidcLogin = startOidcLogin
+
+	// Complete a login when the identity provider redirects back and start
+	// a session for the user
+	FinishOidcLogin = finishOidcLogin
+)
+
+// The provider is discovered on the first login and reused after that
+var oidcProvider struct {
+	sync.Mutex
+	provider *oidc.Provider
+}
+
+type oidcLoginResponse struct {
+	AuthorizationURL string
+	State            string
+}
+
+func startOidcLogin(ctx context.Context) (response interface{}, err error) {
+	provider, err := getOidcProvider(ctx)
+	if err != nil {
+		return
+	}
+
+	state, err := newResetToken()
+	if err != nil {
+		err = wrapInternalError("Failed to create login state", err)
+		return
+	}
+
+	nonce, err := newResetToken()
+	if err != nil {
+		err = wrapInternalError("Failed to create nonce", err)
+		return
+	}
+
+	codeVerifier, err := newResetToken()
+	if err != nil {
+		err = wrapInternalError("Failed to create code verifier", err)
+		return
+	}
+
+	err = data.CreateOidcLogin(
+		ctx,
+		state,
+		nonce,
+		codeVerifier,
+		time.Now().Add(config.GetOidcStateTTL()),
+	)
+	if err != nil {
+		cause := "Failed to store login state"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	response = &oidcLoginResponse{
+		AuthorizationURL: provider.AuthCodeURL(state, nonce, codeVerifier),
+		State:            state,
+	}
+
+	return
+}
+
+// The identity provider reports a failed or cancelled login in the error
+// parameter instead of returning a code
+func finishOidcLogin(
+	ctx context.Context,
+	code, state, providerError string,
+) (response interface{}, err error) {
+	provider, err := getOidcProvider(ctx)
+	if err != nil {
+		return
+	}
+
+	if providerError != "" {
+		cause := "Identity provider refused the login"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeOidcLoginFailed,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	if code == "" || state == "" {
+		cause := "Invalid value for code or state parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	login, err := data.UseOidcLogin(ctx, state)
+	if err != nil {
+		cause := "Failed to get login state"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if login == nil {
+		cause := "Login is unknown or expired"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeOidcLoginFailed,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	claims, err := provider.Exchange(
+		ctx,
+		code,
+		login.CodeVerifier,
+		login.Nonce,
+	)
+	if err != nil {
+		cause := "Failed to verify login with identity provider"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeOidcLoginFailed,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	userId, err := getOidcUserId(ctx, claims)
+	if err != nil {
+		return
+	}
+
+	return startSession(ctx, userId)
+}
+
+// Find the user of the identity. Unknown identities are matched by username
+// and linked to the user, or created as members when auto provisioning is
+// enabled.
+func getOidcUserId(
+	ctx context.Context,
+	claims *oidc.Claims,
+) (userId string, err error) {
+	user, err := data.GetOidcUser(ctx, claims.Issuer, claims.Subject)
+	if err != nil {
+		cause := "Failed to get user"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if user == nil {
+		user, err = linkOidcUser(ctx, claims)
+		if err != nil {
+			return
+		}
+	}
+
+	if user.Status != values.UserStatusActive {
+		cause := "User is not active"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	return user.UserId, nil
+}
+
+func linkOidcUser(
+	ctx context.Context,
+	claims *oidc.Claims,
+) (user *data.UserInfo, err error) {
+	usernameClaim := config.GetOidcUsernameClaim()
+	username := strings.TrimSpace(claims.GetString(usernameClaim))
+
+	// Anyone can claim an email address which the provider has not verified
+	if usernameClaim == "email" &&
+		claims.GetString("email_verified") != "true" {
+		username = ""
+	}
+
+	if username == "" {
+		cause := "Identity provider did not return a verified " +
+			usernameClaim
+		err = util.NewError(
+			cause,
+			util.ErrorCodeOidcLoginFailed,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	var linked int64
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		user, err = data.GetActiveUserByUsername(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		if user == nil && config.GetOidcAutoProvision() {
+			user, err = provisionOidcUser(ctx, username, claims)
+			if err != nil || user == nil {
+				return err
+			}
+		}
+
+		if user == nil {
+			return nil
+		}
+
+		linked, err = data.LinkOidcSubject(
+			ctx,
+			user.UserId,
+			claims.Issuer,
+			claims.Subject,
+		)
+		return err
+	})
+	if err != nil {
+		err = wrapInternalError("Failed to link user", err)
+		return
+	}
+
+	if user == nil {
+		cause := "No active user matches the identity"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeOidcLoginFailed,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	if linked == 0 {
+		cause := "User is linked to another identity"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeOidcLoginFailed,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		user = nil
+		return
+	}
+
+	return
+}
+
+// Create a member for the identity. The random password can only be
+// replaced through a password reset. Must be called within a transaction.
+func provisionOidcUser(
+	ctx context.Context,
+	username string,
+	claims *oidc.Claims,
+) (*data.UserInfo, error) {
+	password, err := newResetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	fullName := strings.TrimSpace(claims.GetString("name"))
+	if fullName == "" {
+		fullName = username
+	}
+
+	return data.CreateUser(
+		ctx,
+		username,
+		password,
+		fullName,
+		values.UserRoleMember,
+		util.NullString{},
+		nil,
+	)
+}
+
+func getOidcProvider(ctx context.Context) (*oidc.Provider, error) {
+	if !config.GetOidcEnabled() {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	oidcProvider.Lock()
+	defer oidcProvider.Unlock()
+
+	if oidcProvider.provider != nil {
+		return oidcProvider.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, oidc.Config{
+		Issuer:       config.GetOidcIssuer(),
+		ClientId:     config.GetOidcClientId(),
+		ClientSecret: config.GetOidcClientSecret(),
+		RedirectURL:  config.GetOidcRedirectURL(),
+		Scopes:       config.GetOidcScopes(),
+		HTTPClient:   &http.Client{Timeout: config.GetOidcHTTPTimeout()},
+	})
+	if err != nil {
+		cause := "Failed to discover identity provider"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return nil, err
+	}
+
+	oidcProvider.provider = provider
+	return provider, nil
+}
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/oidc/oidctest"
+	"github.com/nordluma/go-bookstore/util"
+)
+
+// Enable logins through a fake identity provider which is stopped when the
+// test ends
+func prepareTestOidc(t *testing.T, autoProvision bool) *oidctest.Server {
+	t.Helper()
+
+	idp, err := oidctest.NewServer("bookstore", "secret")
+	if err != nil {
+		t.Fatalf("Failed to start identity provider: %v", err)
+	}
+
+	viper.Set("oidc.enabled", true)
+	viper.Set("oidc.issuer", idp.Issuer())
+	viper.Set("oidc.client_id", idp.ClientId)
+	viper.Set("oidc.client_secret", idp.ClientSecret)
+	viper.Set("oidc.redirect_url", "http://localhost/api/open/oidc/callback")
+	viper.Set("oidc.username_claim", "email")
+	viper.Set("oidc.auto_provision", autoProvision)
+	resetTestOidcProvider()
+
+	t.Cleanup(func() {
+		idp.Close()
+		viper.Set("oidc.enabled", false)
+		resetTestOidcProvider()
+	})
+
+	return idp
+}
+
+// Forget the cached provider, which belongs to the previous fake provider
+func resetTestOidcProvider() {
+	oidcProvider.Lock()
+	defer oidcProvider.Unlock()
+
+	oidcProvider.provider = nil
+}
+
+// Start a login and let the identity provider authorize it with the claims.
+// Returns the code and state which the provider redirects back with.
+func authorizeTestOidcLogin(
+	t *testing.T,
+	idp *oidctest.Server,
+	claims map[string]interface{},
+) (code, state string) {
+	t.Helper()
+
+	response, err := StartOidcLogin(newTestContext(nil))
+	if err != nil {
+		t.Fatalf("Failed to start login: %v", err)
+	}
+
+	login := response.(*oidcLoginResponse)
+	code, err = idp.Authorize(login.AuthorizationURL, claims)
+	if err != nil {
+		t.Fatalf("Failed to authorize login: %v", err)
+	}
+
+	return code, login.State
+}
+
+// Return the claims of a verified identity for the email address
+func newTestClaims(email string) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":            fmt.Sprintf("subject-%d", time.Now().UnixNano()),
+		"email":          email,
+		"email_verified": true,
+	}
+}
+
+func expectNotAuthenticated(t *testing.T, err error) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatal("Expected the login to fail")
+	}
+
+	_, _, _, errorType := util.IsError(err)
+	if errorType != util.ErrNotAuthenticated {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+}
+
+func TestOidcLogin(t *testing.T) {
+	prepareTestDb(t)
+	idp := prepareTestOidc(t, false)
+	member := createTestMember(t, "oidc")
+
+	linkedClaims := newTestClaims(member.Username)
+	code, state := authorizeTestOidcLogin(t, idp, linkedClaims)
+	response, err := FinishOidcLogin(newTestContext(nil), code, state, "")
+	if err != nil {
+		t.Fatalf("Failed to finish login: %v", err)
+	}
+
+	if response == nil {
+		t.Error("Expected a session for the member")
+	}
+
+	linkedUser, err := data.GetOidcUser(
+		newTestContext(nil),
+		idp.Issuer(),
+		linkedClaims["sub"].(string),
+	)
+	if err != nil || linkedUser == nil || linkedUser.UserId != member.UserId {
+		t.Fatalf("Expected the identity to be linked to the member: %v", err)
+	}
+
+	// The identity is linked, so the next login no longer needs the email
+	claims := newTestClaims("")
+	claims["sub"] = linkedClaims["sub"]
+	code, state = authorizeTestOidcLogin(t, idp, claims)
+	_, err = FinishOidcLogin(newTestContext(nil), code, state, "")
+	if err != nil {
+		t.Errorf("Failed to log in with the linked identity: %v", err)
+	}
+}
+
+func TestOidcLoginRejectsUserLinkedToAnotherIdentity(t *testing.T) {
+	prepareTestDb(t)
+	idp := prepareTestOidc(t, true)
+	member := createTestMember(t, "oidc-linked")
+
+	claims := newTestClaims(member.Username)
+	code, state := authorizeTestOidcLogin(t, idp, claims)
+	_, err := FinishOidcLogin(newTestContext(nil), code, state, "")
+	if err != nil {
+		t.Fatalf("Failed to finish login: %v", err)
+	}
+
+	// Another identity claims the same verified email address
+	code, state = authorizeTestOidcLogin(t, idp, newTestClaims(member.Username))
+	_, err = FinishOidcLogin(newTestContext(nil), code, state, "")
+	expectNotAuthenticated(t, err)
+}
+
+func TestOidcLoginRejectsReplayedState(t *testing.T) {
+	prepareTestDb(t)
+	idp := prepareTestOidc(t, false)
+	member := createTestMember(t, "oidc-replay")
+
+	claims := newTestClaims(member.Username)
+	code, state := authorizeTestOidcLogin(t, idp, claims)
+	_, err := FinishOidcLogin(newTestContext(nil), code, state, "")
+	if err != nil {
+		t.Fatalf("Failed to finish login: %v", err)
+	}
+
+	_, err = FinishOidcLogin(newTestContext(nil), code, state, "")
+	expectNotAuthenticated(t, err)
+}
+
+func TestOidcLoginRejectsNonceMismatch(t *testing.T) {
+	prepareTestDb(t)
+	idp := prepareTestOidc(t, false)
+	member := createTestMember(t, "oidc-nonce")
+
+	claims := newTestClaims(member.Username)
+	claims["nonce"] = "other-nonce"
+	code, state := authorizeTestOidcLogin(t, idp, claims)
+
+	_, err := FinishOidcLogin(newTestContext(nil), code, state, "")
+	expectNotAuthenticated(t, err)
+}
+
+func TestOidcLoginRejectsUnverifiedEmail(t *testing.T) {
+	prepareTestDb(t)
+	idp := prepareTestOidc(t, true)
+	member := createTestMember(t, "oidc-unverified")
+
+	claims := newTestClaims(member.Username)
+	claims["email_verified"] = false
+	code, state := authorizeTestOidcLogin(t, idp, claims)
+
+	_, err := FinishOidcLogin(newTestContext(nil), code, state, "")
+	expectNotAuthenticated(t, err)
+}
+
+func TestOidcLoginAutoProvision(t *testing.T) {
+	prepareTestDb(t)
+
+	for _, autoProvision := range []bool{false, true} {
+		name := fmt.Sprintf("auto provision %v", autoProvision)
+		t.Run(name, func(t *testing.T) {
+			idp := prepareTestOidc(t, autoProvision)
+			username := fmt.Sprintf(
+				"oidc-new-%d@example.com",
+				time.Now().UnixNano(),
+			)
+
+			// Remove the member in case the login creates one
+			t.Cleanup(func() {
+				user, err := data.GetActiveUserByUsername(
+					newTestContext(nil),
+					username,
+				)
+				if err != nil {
+					t.Errorf("Failed to get user: %v", err)
+				}
+
+				if user != nil {
+					deleteTestUser(t, user.UserId)
+				}
+			})
+
+			code, state := authorizeTestOidcLogin(
+				t,
+				idp,
+				newTestClaims(username),
+			)
+			_, err := FinishOidcLogin(newTestContext(nil), code, state, "")
+			if !autoProvision {
+				expectNotAuthenticated(t, err)
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to finish login: %v", err)
+			}
+
+			user, err := data.GetActiveUserByUsername(
+				newTestContext(nil),
+				username,
+			)
+			if err != nil || user == nil {
+				t.Fatalf("Expected a member to be created: %v", err)
+			}
+		})
+	}
+}
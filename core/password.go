@@ -0,0 +1,367 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/notifier"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Change the password of the user and revoke their other sessions
+	ChangePassword = changePassword
+
+	// Send a password reset token to an active user
+	RequestPasswordReset = requestPasswordReset
+
+	// Set a new password with a password reset token
+	ResetPassword = resetPassword
+)
+
+func changePassword(
+	ctx context.Context,
+	requestBody io.Reader,
+) (err error) {
+	type changePasswordRequest struct {
+		OldPassword string
+		NewPassword string
+	}
+
+	request := &changePasswordRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.OldPassword = strings.TrimSpace(request.OldPassword)
+	request.NewPassword = strings.TrimSpace(request.NewPassword)
+	if request.OldPassword == request.NewPassword {
+		cause := "New password must differ from the old password"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	err = validatePassword(request.NewPassword)
+	if err != nil {
+		return
+	}
+
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	sessionId, err := getSessionId(ctx)
+	if err != nil {
+		return
+	}
+
+	matches, err := data.CheckPassword(ctx, userId, request.OldPassword)
+	if err != nil {
+		cause := "Failed to check password"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if !matches {
+		cause := "Old password is incorrect"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		_, err := data.ChangePassword(ctx, userId, request.NewPassword)
+		if err != nil {
+			return err
+		}
+
+		_, err = data.RevokeOtherUserSessions(ctx, userId, sessionId)
+		return err
+	})
+	if err != nil {
+		cause := "Failed to change password"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+// Issue a reset token and deliver it to the user. Unknown usernames are not
+// reported so that the endpoint can't be used to find out which users exist.
+func requestPasswordReset(
+	ctx context.Context,
+	requestBody io.Reader,
+) (err error) {
+	type passwordResetRequest struct {
+		Username string
+	}
+
+	request := &passwordResetRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.Username = strings.TrimSpace(request.Username)
+	if request.Username == "" {
+		cause := "Invalid value for username parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	user, err := data.GetActiveUserByUsername(ctx, request.Username)
+	if err != nil {
+		cause := "Failed to get user"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if user == nil {
+		return
+	}
+
+	resetToken, err := newResetToken()
+	if err != nil {
+		cause := "Failed to generate reset token"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	expiresAt := time.Now().Add(config.GetPasswordResetTokenTTL())
+	err = data.CreatePasswordReset(ctx, user.UserId, resetToken, expiresAt)
+	if err != nil {
+		cause := "Failed to create password reset"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	err = notifier.Send(ctx, &notifier.Message{
+		Recipient: user.Username,
+		Subject:   "Password reset",
+		Body: fmt.Sprintf(
+			"Use the token %v to reset your password before %v.",
+			resetToken,
+			expiresAt.Format(time.RFC1123),
+		),
+	})
+	if err != nil {
+		cause := "Failed to send password reset"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func resetPassword(
+	ctx context.Context,
+	requestBody io.Reader,
+) (err error) {
+	type resetPasswordRequest struct {
+		Token       string
+		NewPassword string
+	}
+
+	request := &resetPasswordRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.Token = strings.TrimSpace(request.Token)
+	if request.Token == "" {
+		cause := "Invalid value for token parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.NewPassword = strings.TrimSpace(request.NewPassword)
+	err = validatePassword(request.NewPassword)
+	if err != nil {
+		return
+	}
+
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		userId, err := data.UsePasswordReset(ctx, request.Token)
+		if err != nil {
+			return err
+		}
+
+		if userId == "" {
+			cause := "Reset token is invalid or expired"
+			return util.NewError(
+				cause,
+				util.ErrorCodeInvalidCredentials,
+				util.ErrBadRequest,
+				err,
+			)
+		}
+
+		_, err = data.ChangePassword(ctx, userId, request.NewPassword)
+		if err != nil {
+			return err
+		}
+
+		// Whoever knew the old password must not stay logged in
+		_, err = data.RevokeUserSessions(ctx, userId)
+		return err
+	})
+
+	return wrapInternalError("Failed to reset password", err)
+}
+
+// Check the password against the policy in the configuration
+func validatePassword(password string) (err error) {
+	if len(password) < config.GetPasswordMinLength() {
+		cause := fmt.Sprintf(
+			"Password must be at least %d characters long",
+			config.GetPasswordMinLength(),
+		)
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	// bcrypt ignores everything after the 72nd byte
+	if len(password) > 72 {
+		cause := "Password must be at most 72 bytes long"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	var hasDigit, hasUpper, hasLower bool
+	for _, r := range password {
+		hasDigit = hasDigit || unicode.IsDigit(r)
+		hasUpper = hasUpper || unicode.IsUpper(r)
+		hasLower = hasLower || unicode.IsLower(r)
+	}
+
+	if config.GetPasswordRequireDigit() && !hasDigit {
+		cause := "Password must contain a digit"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	if config.GetPasswordRequireMixedCase() && !(hasUpper && hasLower) {
+		cause := "Password must contain upper and lower case letters"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func newResetToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,348 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Returns the authenticated user of the credentials of a request with
+	// their permissions
+	AuthenticateUser = authenticateRequest
+
+	// Returns a copy of the context which carries the authenticated user
+	WithPrincipal = withPrincipal
+
+	// Returns the authenticated user of the request, or nil
+	GetPrincipal = getPrincipalOrNil
+
+	rolePermissions = &permissionCache{
+		roles: make(map[int]*cachedPermissions),
+	}
+)
+
+// Credentials from the Authorization header of a request. A token is either
+// a session token or an API key, Basic credentials carry a username and a
+// password instead.
+type Credentials struct {
+	Scheme   string
+	Token    string
+	Username string
+	Password string
+}
+
+// The authenticated user of a request. Requests made with an API key have
+// the id of the key and act on behalf of the user who created it. Only
+// requests made with a session token have a session id.
+type Principal struct {
+	UserId      string
+	UserRole    int
+	SessionId   string
+	ApiKeyId    string
+	permissions map[string]bool
+	allowedIPs  []string
+}
+
+// Returns true if the role of the user grants the permission
+func (p *Principal) HasPermission(permission string) bool {
+	return p != nil && p.permissions[permission]
+}
+
+// Permissions of roles are kept in memory so that checking them doesn't cost
+// a database round trip on every request. Entries expire so that changes
+// made by other server instances are picked up.
+type permissionCache struct {
+	mutex sync.RWMutex
+	roles map[int]*cachedPermissions
+}
+
+type cachedPermissions struct {
+	permissions map[string]bool
+	loadedAt    time.Time
+}
+
+func (c *permissionCache) get(userRole int) map[string]bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	cached, ok := c.roles[userRole]
+	if !ok ||
+		time.Since(cached.loadedAt) > config.GetAuthPermissionCacheTTL() {
+		return nil
+	}
+
+	return cached.permissions
+}
+
+func (c *permissionCache) set(userRole int, permissions map[string]bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.roles[userRole] = &cachedPermissions{
+		permissions: permissions,
+		loadedAt:    time.Now(),
+	}
+}
+
+func (c *permissionCache) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.roles = make(map[int]*cachedPermissions)
+}
+
+func authenticateRequest(
+	ctx context.Context,
+	credentials *Credentials,
+	clientIP string,
+) (response *Principal, err error) {
+	if credentials == nil {
+		cause := "Authorization header is missing"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	switch credentials.Scheme {
+	case values.AuthSchemeBasic:
+		response, err = authenticateBasic(
+			ctx,
+			credentials.Username,
+			credentials.Password,
+			clientIP,
+		)
+	case values.AuthSchemeApiKey:
+		if !strings.HasPrefix(credentials.Token, values.ApiKeyPrefix) {
+			cause := "Invalid API key"
+			err = util.NewError(
+				cause,
+				util.ErrorCodeInvalidCredentials,
+				util.ErrNotAuthenticated,
+				err,
+			)
+			return
+		}
+
+		response, err = authenticateApiKey(ctx, credentials.Token)
+	default:
+		response, err = authenticateUser(ctx, credentials.Token)
+	}
+	if err != nil {
+		return
+	}
+
+	if !isClientIPAllowed(response, clientIP) {
+		cause := "API key is not allowed from " + clientIP
+		err = util.NewError(
+			cause,
+			util.ErrorCodePermissionDenied,
+			util.ErrForbidden,
+			err,
+		)
+		return nil, err
+	}
+
+	return
+}
+
+func authenticateUser(
+	ctx context.Context,
+	token string,
+) (response *Principal, err error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		cause := "Token is empty"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	if strings.HasPrefix(token, values.ApiKeyPrefix) {
+		return authenticateApiKey(ctx, token)
+	}
+
+	var userId, sessionId string
+	var userRole int
+	if isSignedTokenMode() {
+		claims, err := verifySignedToken(token)
+		if err != nil {
+			return nil, err
+		}
+
+		userId, userRole = claims.UserId, claims.UserRole
+		sessionId = claims.SessionId
+	} else {
+		// Postgres refuses to compare a malformed token with the uuid
+		// column, which would be reported as an internal error
+		if !isValidUUID(token) {
+			cause := "Invalid token"
+			err := util.NewError(
+				cause,
+				util.ErrorCodeInvalidCredentials,
+				util.ErrNotAuthenticated,
+				nil,
+			)
+			return nil, err
+		}
+
+		user, err := data.GetSessionUser(ctx, token)
+		if err != nil {
+			cause := "Failed to authorize user"
+			err = util.NewError(
+				cause,
+				util.ErrorCodeInternal,
+				util.ErrInternal,
+				err,
+			)
+			return nil, err
+		}
+
+		if user == nil {
+			cause := "Session is expired or revoked"
+			err = util.NewError(
+				cause,
+				util.ErrorCodeInvalidCredentials,
+				util.ErrNotAuthenticated,
+				err,
+			)
+			return nil, err
+		}
+
+		userId, userRole = user.UserId, int(user.UserRole)
+		sessionId = user.SessionId
+	}
+
+	permissions, err := getRolePermissions(ctx, userRole)
+	if err != nil {
+		return
+	}
+
+	response = &Principal{
+		UserId:      userId,
+		UserRole:    userRole,
+		SessionId:   sessionId,
+		permissions: permissions,
+	}
+
+	return
+}
+
+// Check the password of the user on every request. Failed attempts are
+// throttled in the same way as failed logins.
+func authenticateBasic(
+	ctx context.Context,
+	username, password, clientIP string,
+) (response *Principal, err error) {
+	if !config.GetAuthBasicEnabled() {
+		cause := "Basic authentication is not enabled"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	userId, err := verifyLogin(ctx, username, password, clientIP)
+	if err != nil {
+		return
+	}
+
+	user, err := data.GetUser(ctx, userId)
+	if err != nil || user == nil {
+		cause := "Failed to get user"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	permissions, err := getRolePermissions(ctx, int(user.UserRole))
+	if err != nil {
+		return
+	}
+
+	response = &Principal{
+		UserId:      userId,
+		UserRole:    int(user.UserRole),
+		permissions: permissions,
+	}
+
+	return
+}
+
+func withPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, values.ContextKeyPrincipal, principal)
+}
+
+func getPrincipalOrNil(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(values.ContextKeyPrincipal).(*Principal)
+	return principal
+}
+
+// Return the authenticated user of the request. Fails for requests which
+// were not authenticated.
+func getPrincipal(ctx context.Context) (principal *Principal, err error) {
+	principal = getPrincipalOrNil(ctx)
+	if principal == nil {
+		cause := "Request is not authenticated"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func getRolePermissions(
+	ctx context.Context,
+	userRole int,
+) (permissions map[string]bool, err error) {
+	permissions = rolePermissions.get(userRole)
+	if permissions != nil {
+		return
+	}
+
+	list, err := data.GetRolePermissions(ctx, userRole)
+	if err != nil {
+		cause := "Failed to get permissions"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	permissions = make(map[string]bool, len(list))
+	for _, permission := range list {
+		permissions[permission] = true
+	}
+
+	rolePermissions.set(userRole, permissions)
+	return
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Register a new member which has to be approved by a librarian
+	Register = register
+
+	// Returns a list of pending registrations
+	GetRegistrations = getRegistrations
+
+	// Activate a pending member
+	ApproveRegistration = approveRegistration
+
+	// Reject a pending member
+	RejectRegistration = rejectRegistration
+)
+
+func register(
+	ctx context.Context,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type registerRequest struct {
+		Username string
+		Password string
+		FullName string
+	}
+
+	request := &registerRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.Username = strings.TrimSpace(request.Username)
+	request.Password = strings.TrimSpace(request.Password)
+	request.FullName = strings.TrimSpace(request.FullName)
+	if request.Username == "" ||
+		request.Password == "" ||
+		request.FullName == "" {
+		cause := "Username, password and full name are required"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	err = validatePassword(request.Password)
+	if err != nil {
+		return
+	}
+
+	user, err := data.CreatePendingUser(
+		ctx,
+		request.Username,
+		request.Password,
+		request.FullName,
+	)
+	if err != nil {
+		cause := "Failed to register user"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if user == nil {
+		cause := "Username is already taken"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeConflict,
+			util.ErrConflict,
+			err,
+		)
+		return
+	}
+
+	response = user
+	return
+}
+
+func getRegistrations(
+	ctx context.Context,
+	rowOffset, rowLimit int,
+) (response interface{}, err error) {
+	rowLimit, err = validatePaging(rowOffset, rowLimit)
+	if err != nil {
+		return
+	}
+
+	users, err := data.GetUsersByStatus(
+		ctx,
+		values.UserStatusPending,
+		rowOffset,
+		rowLimit,
+	)
+	if err != nil {
+		cause := "Failed to get registrations"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	response = newListResponse(users, "", rowOffset, rowLimit)
+	return
+}
+
+func approveRegistration(
+	ctx context.Context,
+	userId string,
+) (response interface{}, err error) {
+	return reviewRegistration(ctx, userId, values.UserStatusActive)
+}
+
+func rejectRegistration(
+	ctx context.Context,
+	userId string,
+) (response interface{}, err error) {
+	return reviewRegistration(ctx, userId, values.UserStatusRejected)
+}
+
+func reviewRegistration(
+	ctx context.Context,
+	userId string,
+	status int,
+) (response interface{}, err error) {
+	userId, err = validateOtherUserId(ctx, userId)
+	if err != nil {
+		return
+	}
+
+	librarianId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	user, err := data.ReviewUser(ctx, userId, status, librarianId)
+	if err != nil {
+		cause := "Failed to review registration"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if user == nil {
+		cause := "Pending registration not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return
+	}
+
+	response = user
+	return
+}
@@ -0,0 +1,361 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Returns all roles with their permissions
+	GetRoles = getRoles
+
+	// Returns all permissions which roles can grant
+	GetPermissions = getPermissions
+
+	// Create a role
+	CreateRole = createRole
+
+	// Rename a role and replace its permissions
+	UpdateRole = updateRole
+
+	// Delete a role which no user has
+	DeleteRole = deleteRole
+
+	// Permissions which roles can grant
+	knownPermissions = map[string]bool{
+		values.PermissionBookRead:     true,
+		values.PermissionBookWrite:    true,
+		values.PermissionLoanSelf:     true,
+		values.PermissionLoanCheckout: true,
+		values.PermissionFineManage:   true,
+		values.PermissionUserManage:   true,
+		values.PermissionRoleManage:   true,
+		values.PermissionApiKeyManage: true,
+	}
+)
+
+type roleRequest struct {
+	Name        string
+	Permissions []string
+}
+
+func getRoles(ctx context.Context) (response interface{}, err error) {
+	response, err = data.GetRoles(ctx)
+	if err != nil {
+		cause := "Failed to get roles"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func getPermissions(ctx context.Context) (response interface{}, err error) {
+	response, err = data.GetPermissions(ctx)
+	if err != nil {
+		cause := "Failed to get permissions"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func createRole(
+	ctx context.Context,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	request, err := decodeRoleRequest(requestBody)
+	if err != nil {
+		return
+	}
+
+	err = checkRoleNameAvailable(ctx, request.Name, values.UserRoleUnknown)
+	if err != nil {
+		return
+	}
+
+	var userRole int64
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		userRole, err = data.CreateRole(ctx, request.Name)
+		if err != nil {
+			return err
+		}
+
+		return data.SetRolePermissions(
+			ctx,
+			int(userRole),
+			request.Permissions,
+		)
+	})
+	if err != nil {
+		cause := "Failed to create role"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	// The id may have belonged to a deleted role
+	rolePermissions.invalidate()
+
+	return &data.RoleInfo{
+		UserRole:    userRole,
+		Name:        request.Name,
+		Permissions: request.Permissions,
+	}, nil
+}
+
+func updateRole(
+	ctx context.Context,
+	roleId string,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	userRole, err := validateRoleId(roleId)
+	if err != nil {
+		return
+	}
+
+	// Taking the permissions away from the admin role could leave nobody
+	// who is able to manage roles
+	if userRole == values.UserRoleAdmin {
+		cause := "The admin role can't be changed"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request, err := decodeRoleRequest(requestBody)
+	if err != nil {
+		return
+	}
+
+	err = checkRoleNameAvailable(ctx, request.Name, userRole)
+	if err != nil {
+		return
+	}
+
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		rowsAffected, err := data.RenameRole(ctx, userRole, request.Name)
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return newRoleNotFoundError()
+		}
+
+		return data.SetRolePermissions(ctx, userRole, request.Permissions)
+	})
+	if err != nil {
+		err = wrapInternalError("Failed to update role", err)
+		return
+	}
+
+	rolePermissions.invalidate()
+
+	return &data.RoleInfo{
+		UserRole:    int64(userRole),
+		Name:        request.Name,
+		Permissions: request.Permissions,
+	}, nil
+}
+
+func deleteRole(ctx context.Context, roleId string) (err error) {
+	userRole, err := validateRoleId(roleId)
+	if err != nil {
+		return
+	}
+
+	if userRole == values.UserRoleMember ||
+		userRole == values.UserRoleLibrarian ||
+		userRole == values.UserRoleAdmin {
+		cause := "Built-in roles can't be deleted"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	users, err := data.CountUsersWithRole(ctx, userRole)
+	if err != nil {
+		cause := "Failed to count users"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if users > 0 {
+		cause := "Role is assigned to users"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeConflict,
+			util.ErrConflict,
+			err,
+		)
+		return
+	}
+
+	rowsAffected, err := data.DeleteRole(ctx, userRole)
+	if err != nil {
+		cause := "Failed to delete role"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if rowsAffected == 0 {
+		err = newRoleNotFoundError()
+		return
+	}
+
+	rolePermissions.invalidate()
+	return
+}
+
+func decodeRoleRequest(requestBody io.Reader) (*roleRequest, error) {
+	request := &roleRequest{}
+	err := json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		return nil, util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		cause := "Invalid value for name parameter"
+		return nil, util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			nil,
+		)
+	}
+
+	unique := make(map[string]bool, len(request.Permissions))
+	permissions := make([]string, 0, len(request.Permissions))
+	for _, permission := range request.Permissions {
+		permission = strings.TrimSpace(permission)
+		if !knownPermissions[permission] {
+			cause := "Unknown permission " + permission
+			return nil, util.NewError(
+				cause,
+				util.ErrorCodeValidation,
+				util.ErrBadRequest,
+				nil,
+			)
+		}
+
+		if !unique[permission] {
+			unique[permission] = true
+			permissions = append(permissions, permission)
+		}
+	}
+
+	sort.Strings(permissions)
+	request.Permissions = permissions
+
+	return request, nil
+}
+
+// Make sure that no other role than the given one has the name
+func checkRoleNameAvailable(
+	ctx context.Context,
+	name string,
+	userRole int,
+) (err error) {
+	existingRole, err := data.GetRoleIdByName(ctx, name)
+	if err != nil {
+		cause := "Failed to get role"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if existingRole != values.UserRoleUnknown &&
+		int(existingRole) != userRole {
+		cause := "Role name is already taken"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeConflict,
+			util.ErrConflict,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func validateRoleId(roleId string) (int, error) {
+	userRole, err := strconv.Atoi(strings.TrimSpace(roleId))
+	if err != nil || userRole <= values.UserRoleUnknown {
+		cause := "Invalid value for role id parameter"
+		return 0, util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+	}
+
+	return userRole, nil
+}
+
+func newRoleNotFoundError() error {
+	cause := "Role not found"
+	return util.NewError(
+		cause,
+		util.ErrorCodeEntityNotFound,
+		util.ErrResourceNotFound,
+		nil,
+	)
+}
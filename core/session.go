@@ -0,0 +1,231 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Replaces the token of the session and extends its expiry
+	RefreshSession = refreshSession
+
+	// Revokes the session of the request
+	Logout = logout
+
+	// Revokes all sessions of the user
+	LogoutAll = logoutAll
+)
+
+type sessionResponse struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func newSessionResponse(session *data.SessionEntity) *sessionResponse {
+	return &sessionResponse{
+		Token:     session.Token,
+		ExpiresAt: session.ExpiresAt,
+	}
+}
+
+// Create a session for a user who has logged in
+func startSession(
+	ctx context.Context,
+	userId string,
+) (response interface{}, err error) {
+	session, err := data.CreateSession(
+		ctx,
+		userId,
+		time.Now().Add(config.GetAuthSessionTTL()),
+	)
+	if err != nil {
+		cause := "Failed to create session"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if isSignedTokenMode() {
+		return newSignedSessionResponse(ctx, session)
+	}
+
+	response = newSessionResponse(session)
+	return
+}
+
+// Issue a signed token for the session. The token expires with the session
+// at the latest, and the role it carries is read again on every refresh.
+func newSignedSessionResponse(
+	ctx context.Context,
+	session *data.SessionEntity,
+) (response interface{}, err error) {
+	userRole, err := data.AuthorizeUser(ctx, session.Token)
+	if err != nil {
+		cause := "Failed to get user role"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if userRole == values.UserRoleUnknown {
+		cause := "User is not active"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	issuedAt := time.Now()
+	expiresAt := issuedAt.Add(config.GetAuthSignedTokenTTL())
+	if session.ExpiresAt.Before(expiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	token, err := issueSignedToken(&signedTokenClaims{
+		UserId:    session.UserId,
+		UserRole:  int(userRole),
+		SessionId: session.SessionId,
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		err = wrapInternalError("Failed to sign token", err)
+		return
+	}
+
+	response = &sessionResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}
+
+	return
+}
+
+// Refreshing checks that the session is still active, unlike verifying a
+// signed token
+func refreshSession(ctx context.Context) (response interface{}, err error) {
+	sessionId, err := getSessionId(ctx)
+	if err != nil {
+		return
+	}
+
+	expiresAt := time.Now().Add(config.GetAuthSessionTTL())
+
+	var session *data.SessionEntity
+	if isSignedTokenMode() {
+		// The token is signed again, so the session keeps its token
+		session, err = data.ExtendSession(ctx, sessionId, expiresAt)
+	} else {
+		session, err = data.RefreshSession(ctx, sessionId, expiresAt)
+	}
+	if err != nil {
+		cause := "Failed to refresh session"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if session == nil {
+		cause := "Session is expired or revoked"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	if isSignedTokenMode() {
+		return newSignedSessionResponse(ctx, session)
+	}
+
+	response = newSessionResponse(session)
+	return
+}
+
+// Revoke the session of the request. A signed token stays valid until it
+// expires, but it can't be refreshed anymore.
+func logout(ctx context.Context) (err error) {
+	sessionId, err := getSessionId(ctx)
+	if err != nil {
+		return
+	}
+
+	_, err = data.RevokeSessionById(ctx, sessionId)
+	if err != nil {
+		cause := "Failed to revoke session"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+func logoutAll(ctx context.Context) (err error) {
+	userId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	_, err = data.RevokeUserSessions(ctx, userId)
+	if err != nil {
+		cause := "Failed to revoke sessions"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+// Return the id of the session which the request was authenticated with.
+// Requests made with an API key or a password have no session.
+func getSessionId(ctx context.Context) (sessionId string, err error) {
+	principal, err := getPrincipal(ctx)
+	if err != nil {
+		return
+	}
+
+	if principal.SessionId == "" {
+		cause := "Request is not authenticated with a session"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	return principal.SessionId, nil
+}
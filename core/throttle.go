@@ -0,0 +1,243 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Clear the failed logins and lock of a user
+	UnlockUser = unlockUser
+)
+
+// Failed logins are tracked separately for the username and the client IP
+type loginThrottleKey struct {
+	key         string
+	maxAttempts int64
+}
+
+func getLoginThrottleKeys(username, clientIP string) []loginThrottleKey {
+	keys := []loginThrottleKey{
+		{
+			key:         usernameThrottleKey(username),
+			maxAttempts: config.GetLoginMaxFailedAttempts(),
+		},
+	}
+
+	if clientIP != "" {
+		keys = append(keys, loginThrottleKey{
+			key:         "ip:" + clientIP,
+			maxAttempts: config.GetLoginMaxFailedAttemptsPerIP(),
+		})
+	}
+
+	return keys
+}
+
+func usernameThrottleKey(username string) string {
+	return "user:" + username
+}
+
+// Check the password of the user with the failed logins of its keys locked,
+// so that parallel attempts are counted one after another and can't slip
+// past the backoff. Returns the id of the user.
+func verifyLogin(
+	ctx context.Context,
+	username, password, clientIP string,
+) (userId string, err error) {
+	throttleKeys := getLoginThrottleKeys(username, clientIP)
+	loginFailed := false
+
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		err := checkLoginThrottle(ctx, throttleKeys)
+		if err != nil {
+			return err
+		}
+
+		userId, err = data.LoginUser(ctx, username, password)
+		if err != nil {
+			return err
+		}
+
+		// The failure is committed, so the error is returned afterwards
+		if userId == "" {
+			loginFailed = true
+			return recordLoginFailure(ctx, throttleKeys)
+		}
+
+		_, err = data.ClearLoginThrottle(ctx, usernameThrottleKey(username))
+		return err
+	})
+	if err != nil {
+		err = wrapInternalError("Failed to login user", err)
+		return
+	}
+
+	if loginFailed {
+		cause := "Invalid username or password"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return
+	}
+
+	return
+}
+
+// Refuse the login if a key is locked or if it is attempted again before
+// the backoff delay of the previous failure has passed. The keys stay locked
+// until the transaction ends.
+func checkLoginThrottle(
+	ctx context.Context,
+	keys []loginThrottleKey,
+) (err error) {
+	for _, key := range keys {
+		throttle, err := data.LockLoginThrottle(ctx, key.key)
+		if err != nil {
+			cause := "Failed to check failed logins"
+			return util.NewError(
+				cause,
+				util.ErrorCodeInternal,
+				util.ErrInternal,
+				err,
+			)
+		}
+
+		// Taken after the lock, which may have waited for another login
+		now := time.Now()
+
+		if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+			cause := fmt.Sprintf(
+				"Too many failed logins, try again in %v",
+				retryDelay(now, *throttle.LockedUntil),
+			)
+			return util.NewError(
+				cause,
+				util.ErrorCodeAccountLocked,
+				util.ErrTooManyRequests,
+				err,
+			)
+		}
+
+		if throttle.FailedAttempts == 0 ||
+			now.Sub(throttle.LastFailedAt) > config.GetLoginFailureWindow() {
+			continue
+		}
+
+		retryAt := throttle.LastFailedAt.Add(
+			loginBackoff(throttle.FailedAttempts),
+		)
+		if now.Before(retryAt) {
+			cause := fmt.Sprintf(
+				"Login failed recently, try again in %v",
+				retryDelay(now, retryAt),
+			)
+			return util.NewError(
+				cause,
+				util.ErrorCodeLoginThrottled,
+				util.ErrTooManyRequests,
+				err,
+			)
+		}
+	}
+
+	return
+}
+
+// Count the failed login for every key and lock the keys which reached their
+// maximum number of attempts
+func recordLoginFailure(
+	ctx context.Context,
+	keys []loginThrottleKey,
+) (err error) {
+	for _, key := range keys {
+		attempts, err := data.RecordLoginFailure(
+			ctx,
+			key.key,
+			config.GetLoginFailureWindow(),
+		)
+		if err != nil {
+			return wrapInternalError("Failed to record failed login", err)
+		}
+
+		if key.maxAttempts <= 0 || attempts < key.maxAttempts {
+			continue
+		}
+
+		lockedUntil := time.Now().Add(config.GetLoginLockoutPeriod())
+		err = data.LockLogin(ctx, key.key, lockedUntil)
+		if err != nil {
+			return wrapInternalError("Failed to lock login", err)
+		}
+	}
+
+	return
+}
+
+// Return the delay before the next attempt which doubles with every failure
+func loginBackoff(failedAttempts int64) time.Duration {
+	backoff := config.GetLoginBackoffBase()
+	backoffMax := config.GetLoginBackoffMax()
+
+	multiplier := math.Pow(2, float64(failedAttempts-1))
+	if float64(backoff)*multiplier >= float64(backoffMax) {
+		return backoffMax
+	}
+
+	return time.Duration(float64(backoff) * multiplier)
+}
+
+// Return the time left until the retry rounded up to whole seconds
+func retryDelay(now, retryAt time.Time) time.Duration {
+	return (retryAt.Sub(now) + time.Second - 1).Truncate(time.Second)
+}
+
+func unlockUser(ctx context.Context, userId string) (err error) {
+	userId, err = validateUserId(userId)
+	if err != nil {
+		return
+	}
+
+	user, err := data.GetUser(ctx, userId)
+	if err != nil {
+		cause := "Failed to get user"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if user == nil {
+		err = newUserNotFoundError()
+		return
+	}
+
+	_, err = data.ClearLoginThrottle(ctx, usernameThrottleKey(user.Username))
+	if err != nil {
+		cause := "Failed to unlock user"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/util"
+)
+
+func TestFailedLoginsConcurrently(t *testing.T) {
+	prepareTestDb(t)
+
+	const attempts = 8
+
+	member := createTestMember(t, "throttle")
+	t.Cleanup(func() {
+		_, err := data.ClearLoginThrottle(
+			newTestContext(nil),
+			usernameThrottleKey(member.Username),
+		)
+		if err != nil {
+			t.Errorf("Failed to clear failed logins: %v", err)
+		}
+	})
+
+	// All attempts guess the password at the same time
+	start := make(chan struct{})
+	errs := make([]error, attempts)
+
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			body := fmt.Sprintf(
+				`{"Username": %q, "Password": "wrong"}`,
+				member.Username,
+			)
+			<-start
+
+			_, errs[i] = Login(newTestContext(nil), strings.NewReader(body), "")
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+
+	// Only the first attempt is checked, the others are within its backoff
+	failures := 0
+	for i, err := range errs {
+		_, _, _, errorType := util.IsError(err)
+		switch errorType {
+		case util.ErrNotAuthenticated:
+			failures++
+		case util.ErrTooManyRequests:
+		default:
+			t.Errorf("Attempt %d: expected to be refused, got %v", i, err)
+		}
+	}
+
+	if failures != 1 {
+		t.Errorf("Expected one password check, got %d", failures)
+	}
+}
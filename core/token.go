@@ -0,0 +1,202 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Check that the configured token mode can be used, so that the server
+	// doesn't start with tokens which anyone could forge
+	ValidateAuthConfig = validateAuthConfig
+)
+
+const signedTokenAlgorithm = "HS256"
+
+// HMAC-SHA256 keys shorter than the hash can be guessed more easily
+const minSigningKeyLength = 32
+
+type signedTokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyId     string `json:"kid"`
+}
+
+// Claims carried by a signed token
+type signedTokenClaims struct {
+	UserId    string `json:"sub"`
+	UserRole  int    `json:"role"`
+	SessionId string `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Sign the claims with the configured signing key. The token is a JWT which
+// uses HMAC-SHA256 and names the signing key in its header so that keys can
+// be rotated.
+func issueSignedToken(claims *signedTokenClaims) (token string, err error) {
+	keyId := config.GetAuthSigningKeyId()
+	secret := config.GetAuthKey(keyId)
+	if len(secret) < minSigningKeyLength {
+		cause := "Signing key is not configured"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			errors.New(keyId),
+		)
+		return
+	}
+
+	header, err := json.Marshal(&signedTokenHeader{
+		Algorithm: signedTokenAlgorithm,
+		Type:      "JWT",
+		KeyId:     keyId,
+	})
+	if err != nil {
+		return
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return
+	}
+
+	encoding := base64.RawURLEncoding
+	signingInput := encoding.EncodeToString(header) + "." +
+		encoding.EncodeToString(payload)
+
+	token = signingInput + "." +
+		encoding.EncodeToString(signToken(signingInput, secret))
+	return
+}
+
+// Verify the signature and expiry of a signed token and return its claims
+func verifySignedToken(token string) (claims *signedTokenClaims, err error) {
+	claims, err = parseSignedToken(token)
+	if err != nil {
+		cause := "Invalid token"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return nil, err
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		cause := "Token is expired"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return nil, err
+	}
+
+	return
+}
+
+func parseSignedToken(token string) (*signedTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	encoding := base64.RawURLEncoding
+
+	headerJSON, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	header := &signedTokenHeader{}
+	err = json.Unmarshal(headerJSON, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Algorithm != signedTokenAlgorithm {
+		return nil, errors.New("unsupported algorithm " + header.Algorithm)
+	}
+
+	secret := config.GetAuthKey(header.KeyId)
+	if header.KeyId == "" || len(secret) < minSigningKeyLength {
+		return nil, errors.New("unknown key id " + header.KeyId)
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	expected := signToken(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal(signature, expected) {
+		return nil, errors.New("signature mismatch")
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &signedTokenClaims{}
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.UserId == "" || claims.SessionId == "" {
+		return nil, errors.New("missing claims")
+	}
+
+	return claims, nil
+}
+
+func signToken(signingInput, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func validateAuthConfig() error {
+	switch mode := config.GetAuthMode(); mode {
+	case values.AuthModeOpaque:
+		return nil
+	case values.AuthModeSigned:
+	default:
+		return fmt.Errorf("Unknown auth mode %q", mode)
+	}
+
+	keyId := config.GetAuthSigningKeyId()
+	if keyId == "" {
+		return errors.New("Signing key id is empty")
+	}
+
+	if len(config.GetAuthKey(keyId)) < minSigningKeyLength {
+		return fmt.Errorf(
+			"Signing key %q must be at least %d bytes long",
+			keyId,
+			minSigningKeyLength,
+		)
+	}
+
+	return nil
+}
+
+// Returns true when tokens are signed and verified without the database
+func isSignedTokenMode() bool {
+	return config.GetAuthMode() == values.AuthModeSigned
+}
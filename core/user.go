@@ -5,24 +5,47 @@ import (
 	"encoding/json"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/nordluma/go-bookstore/data"
+	"github.com/nordluma/go-bookstore/server/dbserver"
 	"github.com/nordluma/go-bookstore/util"
 	"github.com/nordluma/go-bookstore/values"
 )
 
 var (
-	// Returns user's auth token
+	// Starts a new session and returns its token
 	Login = login
 
-	// Returns user's role if user token exists, if the token doesn's exist
-	// return an empty string
-	AuthorizeUser = authorizeUser
+	// Returns a list of users matching the search term
+	GetUsers = getUsers
+
+	// Returns a user
+	GetUser = getUser
+
+	// Create an active user
+	CreateUser = createUser
+
+	// Update the name and membership of a user
+	UpdateUser = updateUser
+
+	// Change the role of another user
+	ChangeUserRole = changeUserRole
+
+	// Suspend another user and revoke their sessions
+	SuspendUser = suspendUser
+
+	// Reactivate a suspended user
+	UnsuspendUser = unsuspendUser
+
+	// Delete another user who has no books on loan
+	DeleteUser = deleteUser
 )
 
 func login(
 	ctx context.Context,
 	requestBody io.Reader,
+	clientIP string,
 ) (response interface{}, err error) {
 	type loginRequest struct {
 		Username string
@@ -55,47 +78,209 @@ func login(
 		return
 	}
 
-	token, err := data.LoginUser(ctx, request.Username, request.Password)
+	userId, err := verifyLogin(
+		ctx,
+		request.Username,
+		request.Password,
+		clientIP,
+	)
+	if err != nil {
+		return
+	}
+
+	return startSession(ctx, userId)
+}
+
+// Return the id of the authenticated user of the request
+func getUserId(ctx context.Context) (userId string, err error) {
+	principal, err := getPrincipal(ctx)
+	if err != nil {
+		return
+	}
+
+	return principal.UserId, nil
+}
+
+func getUsers(
+	ctx context.Context,
+	searchTerm string,
+	rowOffset, rowLimit int,
+) (response interface{}, err error) {
+	rowLimit, err = validatePaging(rowOffset, rowLimit)
+	if err != nil {
+		return
+	}
+
+	searchTerm = strings.TrimSpace(searchTerm)
+	users, err := data.GetUsers(ctx, searchTerm, rowOffset, rowLimit)
+	if err != nil {
+		cause := "Failed to get users"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	response = newListResponse(users, searchTerm, rowOffset, rowLimit)
+	return
+}
+
+func getUser(
+	ctx context.Context,
+	userId string,
+) (response interface{}, err error) {
+	userId, err = validateUserId(userId)
+	if err != nil {
+		return
+	}
+
+	user, err := data.GetUser(ctx, userId)
+	if err != nil {
+		cause := "Failed to get user"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if user == nil {
+		err = newUserNotFoundError()
+		return
+	}
+
+	response = user
+	return
+}
+
+func createUser(
+	ctx context.Context,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type createUserRequest struct {
+		Username            string
+		Password            string
+		FullName            string
+		UserRole            int
+		MemberCategory      string
+		MembershipExpiresAt *time.Time
+	}
+
+	request := &createUserRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
 	if err != nil {
-		cause := "Failed to login user"
+		cause := "Failed to decode JSON"
 		err = util.NewError(
 			cause,
-			util.ErrorCodeInvalidCredentials,
-			util.ErrNotAuthenticated,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
 			err,
 		)
 		return
 	}
 
-	if token == "" {
-		cause := "Invalid username or password"
+	request.Username = strings.TrimSpace(request.Username)
+	request.Password = strings.TrimSpace(request.Password)
+	request.FullName = strings.TrimSpace(request.FullName)
+	if request.Username == "" ||
+		request.Password == "" ||
+		request.FullName == "" {
+		cause := "Username, password and full name are required"
 		err = util.NewError(
 			cause,
-			util.ErrorCodeInvalidCredentials,
-			util.ErrNotAuthenticated,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
 			err,
 		)
 		return
 	}
 
-	type loginResponse struct {
-		Token string
+	err = validatePassword(request.Password)
+	if err != nil {
+		return
+	}
+
+	if request.UserRole == values.UserRoleUnknown {
+		request.UserRole = values.UserRoleMember
+	}
+
+	err = validateAssignableRole(ctx, request.UserRole)
+	if err != nil {
+		return
+	}
+
+	user, err := data.CreateUser(
+		ctx,
+		request.Username,
+		request.Password,
+		request.FullName,
+		request.UserRole,
+		util.NewNullableString(strings.TrimSpace(request.MemberCategory)),
+		request.MembershipExpiresAt,
+	)
+	if err != nil {
+		cause := "Failed to create user"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
 	}
 
-	response = &loginResponse{
-		Token: token,
+	if user == nil {
+		cause := "Username is already taken"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeConflict,
+			util.ErrConflict,
+			err,
+		)
+		return
 	}
 
+	response = user
 	return
 }
 
-func authorizeUser(
+func updateUser(
 	ctx context.Context,
-	token string,
-) (response int, err error) {
-	token = strings.TrimSpace(token)
-	if token == "" {
-		cause := "Invalid value for token parameter"
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type updateUserRequest struct {
+		UserId              string
+		FullName            string
+		MemberCategory      string
+		MembershipExpiresAt *time.Time
+	}
+
+	request := &updateUserRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.UserId, err = validateUserId(request.UserId)
+	if err != nil {
+		return
+	}
+
+	request.FullName = strings.TrimSpace(request.FullName)
+	if request.FullName == "" {
+		cause := "Invalid value for full name parameter"
 		err = util.NewError(
 			cause,
 			util.ErrorCodeValidation,
@@ -105,9 +290,15 @@ func authorizeUser(
 		return
 	}
 
-	userRole, err := data.AuthorizeUser(ctx, token)
+	user, err := data.UpdateUser(
+		ctx,
+		request.UserId,
+		request.FullName,
+		util.NewNullableString(strings.TrimSpace(request.MemberCategory)),
+		request.MembershipExpiresAt,
+	)
 	if err != nil {
-		cause := "Failed to authorize user"
+		cause := "Failed to update user"
 		err = util.NewError(
 			cause,
 			util.ErrorCodeInternal,
@@ -117,8 +308,178 @@ func authorizeUser(
 		return
 	}
 
-	if userRole == values.UserRoleUnknown {
-		cause := "User not found"
+	if user == nil {
+		err = newUserNotFoundError()
+		return
+	}
+
+	response = user
+	return
+}
+
+func changeUserRole(
+	ctx context.Context,
+	userId string,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type changeUserRoleRequest struct {
+		UserRole int
+	}
+
+	request := &changeUserRoleRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	userId, err = validateOtherUserId(ctx, userId)
+	if err != nil {
+		return
+	}
+
+	err = validateAssignableRole(ctx, request.UserRole)
+	if err != nil {
+		return
+	}
+
+	user, err := data.ChangeUserRole(ctx, userId, request.UserRole)
+	if err != nil {
+		cause := "Failed to change user role"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if user == nil {
+		err = newUserNotFoundError()
+		return
+	}
+
+	response = user
+	return
+}
+
+func suspendUser(
+	ctx context.Context,
+	userId string,
+	requestBody io.Reader,
+) (response interface{}, err error) {
+	type suspendUserRequest struct {
+		Reason string
+	}
+
+	request := &suspendUserRequest{}
+	err = json.NewDecoder(requestBody).Decode(request)
+	if err != nil {
+		cause := "Failed to decode JSON"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidJSONBody,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" {
+		cause := "Invalid value for reason parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	userId, err = validateOtherUserId(ctx, userId)
+	if err != nil {
+		return
+	}
+
+	librarianId, err := getUserId(ctx)
+	if err != nil {
+		return
+	}
+
+	var user *data.UserInfo
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		user, err = data.SuspendUser(ctx, userId, request.Reason, librarianId)
+		if err != nil || user == nil {
+			return err
+		}
+
+		// Suspended users must not keep using their sessions or API keys
+		_, err = data.RevokeUserSessions(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		_, err = data.RevokeUserApiKeys(ctx, userId)
+		return err
+	})
+	if err != nil {
+		cause := "Failed to suspend user"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if user == nil {
+		cause := "Active user not found"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeEntityNotFound,
+			util.ErrResourceNotFound,
+			err,
+		)
+		return
+	}
+
+	response = user
+	return
+}
+
+func unsuspendUser(
+	ctx context.Context,
+	userId string,
+) (response interface{}, err error) {
+	userId, err = validateOtherUserId(ctx, userId)
+	if err != nil {
+		return
+	}
+
+	user, err := data.UnsuspendUser(ctx, userId)
+	if err != nil {
+		cause := "Failed to unsuspend user"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if user == nil {
+		cause := "Suspended user not found"
 		err = util.NewError(
 			cause,
 			util.ErrorCodeEntityNotFound,
@@ -128,6 +489,209 @@ func authorizeUser(
 		return
 	}
 
-	response = int(userRole)
+	response = user
 	return
 }
+
+func deleteUser(ctx context.Context, userId string) (err error) {
+	userId, err = validateOtherUserId(ctx, userId)
+	if err != nil {
+		return
+	}
+
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+	err = dbRunner.Transact(ctx, nil, func() error {
+		// Locking the user keeps new loans from being created meanwhile
+		status, err := data.LockBorrowingStatus(ctx, userId)
+		if err != nil {
+			cause := "Failed to get user"
+			return util.NewError(
+				cause,
+				util.ErrorCodeInternal,
+				util.ErrInternal,
+				err,
+			)
+		}
+
+		if status == nil {
+			return newUserNotFoundError()
+		}
+
+		if status.OpenLoans > 0 {
+			cause := "User has books on loan"
+			return util.NewError(
+				cause,
+				util.ErrorCodeConflict,
+				util.ErrConflict,
+				err,
+			)
+		}
+
+		balance, err := data.GetFineBalance(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		if balance != 0 {
+			cause := "User has an outstanding fine balance"
+			return util.NewError(
+				cause,
+				util.ErrorCodeConflict,
+				util.ErrConflict,
+				err,
+			)
+		}
+
+		// Loan history and fines are kept, so their users can't be deleted
+		records, err := data.CountCirculationRecords(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		if records > 0 {
+			cause := "User has circulation records, suspend the user instead"
+			return util.NewError(
+				cause,
+				util.ErrorCodeConflict,
+				util.ErrConflict,
+				err,
+			)
+		}
+
+		// The keys are kept for the record but must not outlive their user
+		_, err = data.RevokeUserApiKeys(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		_, err = data.DeleteUser(ctx, userId)
+		return err
+	})
+
+	return wrapInternalError("Failed to delete user", err)
+}
+
+func validateUserId(userId string) (string, error) {
+	userId = strings.TrimSpace(userId)
+	if userId == "" {
+		cause := "Invalid value for user id parameter"
+		return "", util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			nil,
+		)
+	}
+
+	return userId, nil
+}
+
+// Validate the user id and make sure that librarians don't lock themselves
+// out by changing their own account, or change users who have permissions
+// which they don't have themselves
+func validateOtherUserId(
+	ctx context.Context,
+	userId string,
+) (string, error) {
+	userId, err := validateUserId(userId)
+	if err != nil {
+		return "", err
+	}
+
+	librarianId, err := getUserId(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if librarianId == userId {
+		cause := "Librarians can't change their own account"
+		return "", util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			nil,
+		)
+	}
+
+	user, err := data.GetUser(ctx, userId)
+	if err != nil {
+		cause := "Failed to get user"
+		return "", util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+	}
+
+	if user == nil {
+		return "", newUserNotFoundError()
+	}
+
+	err = validateAssignableRole(ctx, int(user.UserRole))
+	if err != nil {
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// Validate that the role exists and that it doesn't grant permissions which
+// the user assigning it doesn't have
+func validateAssignableRole(
+	ctx context.Context,
+	userRole int,
+) (err error) {
+	role, err := data.GetRole(ctx, userRole)
+	if err != nil {
+		cause := "Failed to get role"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInternal,
+			util.ErrInternal,
+			err,
+		)
+		return
+	}
+
+	if role == nil {
+		cause := "Invalid value for user role parameter"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeValidation,
+			util.ErrBadRequest,
+			err,
+		)
+		return
+	}
+
+	principal, err := getPrincipal(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, permission := range role.Permissions {
+		if !principal.HasPermission(permission) {
+			cause := "Role grants permissions which the user doesn't have"
+			err = util.NewError(
+				cause,
+				util.ErrorCodePermissionDenied,
+				util.ErrForbidden,
+				err,
+			)
+			return
+		}
+	}
+
+	return
+}
+
+func newUserNotFoundError() error {
+	cause := "User not found"
+	return util.NewError(
+		cause,
+		util.ErrorCodeEntityNotFound,
+		util.ErrResourceNotFound,
+		nil,
+	)
+}
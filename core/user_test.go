@@ -0,0 +1,39 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+func TestUnsuspendUserRequiresPermissionsOfRole(t *testing.T) {
+	prepareTestDb(t)
+
+	librarian := createTestUser(t, "librarian", values.UserRoleLibrarian)
+	admin := createTestUser(t, "admin", values.UserRoleAdmin)
+	execTestQuery(
+		t,
+		"UPDATE library_user SET user_status = $1 WHERE user_id = $2",
+		values.UserStatusSuspended,
+		admin.UserId,
+	)
+
+	ctx := newTestContext(nil)
+	permissions, err := getRolePermissions(ctx, values.UserRoleLibrarian)
+	if err != nil {
+		t.Fatalf("Failed to get permissions: %v", err)
+	}
+
+	ctx = WithPrincipal(ctx, &Principal{
+		UserId:      librarian.UserId,
+		UserRole:    values.UserRoleLibrarian,
+		permissions: permissions,
+	})
+
+	_, err = UnsuspendUser(ctx, admin.UserId)
+	_, _, _, errorType := util.IsError(err)
+	if errorType != util.ErrForbidden {
+		t.Errorf("Expected the librarian to be refused, got %v", err)
+	}
+}
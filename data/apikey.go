@@ -0,0 +1,222 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Store a new API key with its permissions
+	CreateApiKey = createApiKey
+
+	// Return all API keys
+	GetApiKeys = getApiKeys
+
+	// Return the active API key matching the key and record its use. Keys
+	// of users who are not active are not returned.
+	UseApiKey = useApiKey
+
+	// Revoke an API key
+	RevokeApiKey = revokeApiKey
+
+	// Revoke all API keys created by a user
+	RevokeUserApiKeys = revokeUserApiKeys
+)
+
+// Struct which is used when querying for API keys
+type ApiKeyInfo struct {
+	KeyId       string
+	Name        string
+	KeyPrefix   string
+	Permissions []string
+	AllowedIPs  []string
+	CreatedBy   string `json:",omitempty"`
+	CreatedAt   time.Time
+	LastUsedAt  *time.Time `json:",omitempty"`
+	RevokedAt   *time.Time `json:",omitempty"`
+}
+
+// The lists are read by their index, the other columns into the struct
+const apiKeyInfoColumns = `
+            k.key_id AS "KeyId",
+            k.key_name AS "Name",
+            k.key_prefix AS "KeyPrefix",
+            COALESCE((
+                SELECT string_agg(p.permission, ',' ORDER BY p.permission)
+                FROM api_key_permission p
+                WHERE p.key_id = k.key_id
+            ), '') AS "PermissionList",
+            k.allowed_ips AS "AllowedIPList",
+            k.created_by AS "CreatedBy",
+            k.created_at AS "CreatedAt",
+            k.last_used_at AS "LastUsedAt",
+            k.revoked_at AS "RevokedAt"`
+
+// Must be called within a transaction
+func createApiKey(
+	ctx context.Context,
+	name, key, keyPrefix string,
+	permissions, allowedIPs []string,
+	createdBy string,
+) (response *ApiKeyInfo, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        INSERT INTO api_key (
+            key_name, key_prefix, key_hash, allowed_ips, created_by
+        )
+        VALUES ($1, $2, encode(digest($3, 'sha256'), 'hex'), $4, $5)
+        RETURNING key_id`
+
+	keyId, err := executeQueryWithStringResponse(
+		ctx,
+		query,
+		name,
+		keyPrefix,
+		key,
+		strings.Join(allowedIPs, ","),
+		createdBy,
+	)
+	if err != nil {
+		return
+	}
+
+	query = `
+        INSERT INTO api_key_permission (key_id, permission)
+        VALUES ($1, $2)
+        ON CONFLICT DO NOTHING`
+
+	for _, permission := range permissions {
+		_, err = dbRunner.Exec(ctx, query, keyId, permission)
+		if err != nil {
+			return
+		}
+	}
+
+	query = `
+        SELECT` + apiKeyInfoColumns + `
+        FROM api_key k
+        WHERE k.key_id = $1`
+
+	keys, err := queryApiKeys(ctx, query, keyId)
+	if err != nil || len(keys) == 0 {
+		return
+	}
+
+	response = keys[0]
+	return
+}
+
+func getApiKeys(ctx context.Context) (response []*ApiKeyInfo, err error) {
+	query := `
+        SELECT` + apiKeyInfoColumns + `
+        FROM api_key k
+        ORDER BY k.created_at DESC`
+
+	return queryApiKeys(ctx, query)
+}
+
+func useApiKey(
+	ctx context.Context,
+	key string,
+) (response *ApiKeyInfo, err error) {
+	// The time of use is only recorded once a minute, so that every request
+	// of a busy client doesn't write to the table
+	query := `
+        WITH used AS (
+            UPDATE api_key
+            SET last_used_at = now()
+            WHERE key_hash = encode(digest($1, 'sha256'), 'hex')
+            AND revoked_at IS NULL
+            AND (
+                last_used_at IS NULL
+                OR last_used_at < now() - interval '1 minute'
+            )
+        )
+        SELECT` + apiKeyInfoColumns + `
+        FROM api_key k
+        JOIN library_user u ON u.user_id = k.created_by
+        WHERE k.key_hash = encode(digest($1, 'sha256'), 'hex')
+        AND k.revoked_at IS NULL
+        AND u.user_status = $2`
+
+	keys, err := queryApiKeys(ctx, query, key, values.UserStatusActive)
+	if err != nil || len(keys) == 0 {
+		return
+	}
+
+	response = keys[0]
+	return
+}
+
+func revokeApiKey(
+	ctx context.Context,
+	keyId string,
+) (response int64, err error) {
+	query := `
+        UPDATE api_key
+        SET revoked_at = now()
+        WHERE key_id = $1
+        AND revoked_at IS NULL`
+
+	return executeQueryWithRowsAffected(ctx, query, keyId)
+}
+
+func revokeUserApiKeys(
+	ctx context.Context,
+	userId string,
+) (response int64, err error) {
+	query := `
+        UPDATE api_key
+        SET revoked_at = now()
+        WHERE created_by = $1
+        AND revoked_at IS NULL`
+
+	return executeQueryWithRowsAffected(ctx, query, userId)
+}
+
+func queryApiKeys(
+	ctx context.Context,
+	query string,
+	params ...interface{},
+) (response []*ApiKeyInfo, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	rows, err := dbRunner.Query(ctx, query, params...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]*ApiKeyInfo, 0)
+	for rr.ScanNext() {
+		key := &ApiKeyInfo{}
+		rr.ReadAllToStruct(key)
+		key.Permissions = splitList(rr.ReadByIdxString(3))
+		key.AllowedIPs = splitList(rr.ReadByIdxString(4))
+		response = append(response, key)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+// Split a comma separated list into its items
+func splitList(list string) []string {
+	if list == "" {
+		return make([]string, 0)
+	}
+
+	return strings.Split(list, ",")
+}
@@ -28,14 +28,14 @@ var (
 	// Delete a book
 	DeleteBook = deleteBook
 
-	// Get status of book
-	GetBookStatus = getBookStatus
-
-	// Get borrower id of member who has borrowed the book
-	GetBorrowerId = getBorrowerId
-
-	// Change the book status
-	ChangeBookStatus = changeBookStatus
+	// Lock the book row, so that no loans, holds or copies of the book are
+	// created meanwhile. Returns an empty string if the book doesn't exist.
+	// Must be called within a transaction.
+	LockBook = lockBook
+
+	// Return the number of loans and open holds of a book, including
+	// returned loans
+	CountBookCirculationRecords = countBookCirculationRecords
 )
 
 // This struct contains all database columns converted to Go types
@@ -45,10 +45,8 @@ type BookEntity struct {
 	AuthorName  string
 	Publisher   string
 	Description string `json:",omitempty"`
-	Status      int
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
-	BorrowerId  string `json:",omitempty"`
 }
 
 // Struct to decribe a book. This struct is used when quering a single book
@@ -62,20 +60,21 @@ type BookDetails struct {
 
 // Struct which is used when librarians queries for all books
 type BookInfoLibrarian struct {
-	BookId     string
-	BookName   string
-	AuthorName string
-	Publisher  string
-	Status     int64
-	Borrower   string `json:",omitempty"`
+	BookId          string
+	BookName        string
+	AuthorName      string
+	Publisher       string
+	TotalCopies     int64
+	AvailableCopies int64
 }
 
 // Struct which is used when members queries for all books
 type BookInfoMember struct {
-	BookId     string
-	BookName   string
-	AuthorName string
-	Publisher  string
+	BookId          string
+	BookName        string
+	AuthorName      string
+	Publisher       string
+	AvailableCopies int64
 }
 
 func createBook(
@@ -118,10 +117,8 @@ func createBook(
 			AuthorName:  authorName,
 			Publisher:   publisher,
 			Description: util.GetNullStringValue(description),
-			Status:      values.BookStatusAvailable,
 			CreatedAt:   rr.ReadByIdxTime(1),
 			UpdatedAt:   rr.ReadByIdxTime(1),
-			BorrowerId:  "",
 		}
 	}
 
@@ -177,13 +174,18 @@ func getAllBooksForMember(
 
 	query := `
         SELECT
-            book_id AS "BookId",
-            book_name AS "BookName",
-            author_name AS "AuthorName",
-            publisher AS "Publisher"
-        FROM book
-        WHERE book_name LIKE '%%' || $1 || '%%'
-        AND book_status = $2
+            b.book_id AS "BookId",
+            b.book_name AS "BookName",
+            b.author_name AS "AuthorName",
+            b.publisher AS "Publisher",
+            COUNT(c.copy_id) FILTER (
+                WHERE c.copy_status = $2
+            ) AS "AvailableCopies"
+        FROM book b
+        LEFT JOIN book_copy c ON c.book_id = b.book_id
+        WHERE b.book_name LIKE '%%' || $1 || '%%'
+        GROUP BY b.book_id
+        ORDER BY b.book_name
         OFFSET $3
         LIMIT $4`
 
@@ -231,15 +233,26 @@ func getAllBooksForLibrarian(
             b.book_name AS "BookName",
             b.author_name AS "AuthorName",
             b.publisher AS "Publisher",
-            b.book_status AS "Status",
-            u.full_name AS "Borrower"
+            COUNT(c.copy_id) AS "TotalCopies",
+            COUNT(c.copy_id) FILTER (
+                WHERE c.copy_status = $2
+            ) AS "AvailableCopies"
         FROM book b
-        LEFT JOIN library_user u ON u.user_id = b.borrower_id
+        LEFT JOIN book_copy c ON c.book_id = b.book_id
         WHERE b.book_name LIKE '%%' || $1 || '%%'
-        OFFSET $2
-        LIMIT $3`
+        GROUP BY b.book_id
+        ORDER BY b.book_name
+        OFFSET $3
+        LIMIT $4`
 
-	rows, err := dbRunner.Query(ctx, query, searchTerm, rowOffset, rowLimit)
+	rows, err := dbRunner.Query(
+		ctx,
+		query,
+		searchTerm,
+		values.BookStatusAvailable,
+		rowOffset,
+		rowLimit,
+	)
 	if err != nil {
 		return
 	}
@@ -299,39 +312,35 @@ func deleteBook(
 	return executeQueryWithRowsAffected(ctx, query, bookId)
 }
 
-func getBookStatus(
-	ctx context.Context,
-	bookId string,
-) (response int64, err error) {
-	query := `SELECT book_status FROM book WHERE book_id = $1`
-
-	return executeQueryWithInt64Response(ctx, query, bookId)
-}
-
-func changeBookStatus(
-	ctx context.Context,
-	bookId string,
-	status int,
-	userId util.NullString,
-) (err error) {
-	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
-
+func lockBook(ctx context.Context, bookId string) (string, error) {
 	query := `
-        UPDATE book
-        SET
-            book_status = $1,
-            borrower_id = $2,
-        WHERE book_id = $3`
+        SELECT book_id
+        FROM book
+        WHERE book_id = $1
+        FOR UPDATE`
 
-	_, err = dbRunner.Exec(ctx, query, status, userId, bookId)
-	return
+	return executeQueryWithStringResponse(ctx, query, bookId)
 }
 
-func getBorrowerId(
+func countBookCirculationRecords(
 	ctx context.Context,
 	bookId string,
-) (response string, err error) {
-	query := `SELECT borrower_id FROM book WHERE book_id = $1`
-
-	return executeQueryWithStringResponse(ctx, query, bookId)
+) (response int64, err error) {
+	query := `
+        SELECT
+            (SELECT COUNT(*) FROM loan WHERE book_id = $1) +
+            (
+                SELECT COUNT(*)
+                FROM hold
+                WHERE book_id = $1
+                AND hold_status IN ($2, $3)
+            )`
+
+	return executeQueryWithInt64Response(
+		ctx,
+		query,
+		bookId,
+		values.HoldStatusWaiting,
+		values.HoldStatusReady,
+	)
 }
@@ -0,0 +1,436 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Create a new physical copy of a book
+	CreateCopy = createCopy
+
+	// Retrieve a copy
+	GetCopy = getCopy
+
+	// Return all copies of a book for members
+	GetCopiesForMember = getCopiesForMember
+
+	// Return all copies of a book for librarians
+	GetCopiesForLibrarian = getCopiesForLibrarian
+
+	// Update a copy
+	UpdateCopy = updateCopy
+
+	// Delete a copy
+	DeleteCopy = deleteCopy
+
+	// Return the number of loans of a copy and of open holds which wait for
+	// it, including returned loans
+	CountCopyCirculationRecords = countCopyCirculationRecords
+
+	// Change the circulation status of a copy
+	ChangeCopyStatus = changeCopyStatus
+
+	// Lock a copy for the rest of the transaction and return it
+	LockCopy = lockCopy
+
+	// Lock a copy with the given barcode for the rest of the transaction and
+	// return it
+	LockCopyByBarcode = lockCopyByBarcode
+
+	// Change the circulation status of a copy only if it still has the
+	// expected status. Returns the number of changed rows.
+	SwapCopyStatus = swapCopyStatus
+
+	// Return the number of available copies of a book
+	CountAvailableCopies = countAvailableCopies
+)
+
+// This struct contains all database columns converted to Go types
+type CopyEntity struct {
+	CopyId        string
+	BookId        string
+	Barcode       string
+	ShelfLocation string `json:",omitempty"`
+	Condition     string `json:",omitempty"`
+	AcquiredAt    time.Time
+	Status        int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Struct which is used when members queries for copies of a book
+type CopyInfoMember struct {
+	CopyId        string
+	Barcode       string
+	ShelfLocation string `json:",omitempty"`
+	Status        int64
+}
+
+// Struct which is used when librarians queries for copies of a book
+type CopyInfoLibrarian struct {
+	CopyId        string
+	Barcode       string
+	ShelfLocation string `json:",omitempty"`
+	Condition     string `json:",omitempty"`
+	AcquiredAt    time.Time
+	Status        int64
+	Borrower      string     `json:",omitempty"`
+	DueAt         *time.Time `json:",omitempty"`
+}
+
+func createCopy(
+	ctx context.Context,
+	bookId, barcode string,
+	shelfLocation, condition util.NullString,
+	acquiredAt time.Time,
+) (response *CopyEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        INSERT INTO book_copy (
+            book_id, barcode, shelf_location, copy_condition, acquired_at
+        )
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING copy_id, acquired_at, created_at`
+
+	rows, err := dbRunner.Query(
+		ctx,
+		query,
+		bookId,
+		barcode,
+		shelfLocation,
+		condition,
+		acquiredAt,
+	)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &CopyEntity{
+			CopyId:        rr.ReadByIdxString(0),
+			BookId:        bookId,
+			Barcode:       barcode,
+			ShelfLocation: util.GetNullStringValue(shelfLocation),
+			Condition:     util.GetNullStringValue(condition),
+			AcquiredAt:    rr.ReadByIdxTime(1),
+			Status:        values.BookStatusAvailable,
+			CreatedAt:     rr.ReadByIdxTime(2),
+			UpdatedAt:     rr.ReadByIdxTime(2),
+		}
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func getCopy(
+	ctx context.Context,
+	copyId string,
+) (response *CopyEntity, err error) {
+	query := `
+        SELECT
+            copy_id AS "CopyId",
+            book_id AS "BookId",
+            barcode AS "Barcode",
+            shelf_location AS "ShelfLocation",
+            copy_condition AS "Condition",
+            acquired_at AS "AcquiredAt",
+            copy_status AS "Status",
+            created_at AS "CreatedAt",
+            updated_at AS "UpdatedAt"
+        FROM book_copy
+        WHERE copy_id = $1`
+
+	return queryCopy(ctx, query, copyId)
+}
+
+func lockCopy(
+	ctx context.Context,
+	copyId string,
+) (response *CopyEntity, err error) {
+	query := `
+        SELECT
+            copy_id AS "CopyId",
+            book_id AS "BookId",
+            barcode AS "Barcode",
+            shelf_location AS "ShelfLocation",
+            copy_condition AS "Condition",
+            acquired_at AS "AcquiredAt",
+            copy_status AS "Status",
+            created_at AS "CreatedAt",
+            updated_at AS "UpdatedAt"
+        FROM book_copy
+        WHERE copy_id = $1
+        FOR UPDATE`
+
+	return queryCopy(ctx, query, copyId)
+}
+
+func lockCopyByBarcode(
+	ctx context.Context,
+	barcode string,
+) (response *CopyEntity, err error) {
+	query := `
+        SELECT
+            copy_id AS "CopyId",
+            book_id AS "BookId",
+            barcode AS "Barcode",
+            shelf_location AS "ShelfLocation",
+            copy_condition AS "Condition",
+            acquired_at AS "AcquiredAt",
+            copy_status AS "Status",
+            created_at AS "CreatedAt",
+            updated_at AS "UpdatedAt"
+        FROM book_copy
+        WHERE barcode = $1
+        FOR UPDATE`
+
+	return queryCopy(ctx, query, barcode)
+}
+
+func queryCopy(
+	ctx context.Context,
+	query string,
+	params ...interface{},
+) (response *CopyEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	rows, err := dbRunner.Query(ctx, query, params...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &CopyEntity{}
+		rr.ReadAllToStruct(response)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func getCopiesForMember(
+	ctx context.Context,
+	bookId string,
+) (response []*CopyInfoMember, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        SELECT
+            copy_id AS "CopyId",
+            barcode AS "Barcode",
+            shelf_location AS "ShelfLocation",
+            copy_status AS "Status"
+        FROM book_copy
+        WHERE book_id = $1
+        ORDER BY barcode`
+
+	rows, err := dbRunner.Query(ctx, query, bookId)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]*CopyInfoMember, 0)
+	for rr.ScanNext() {
+		bookCopy := &CopyInfoMember{}
+		rr.ReadAllToStruct(bookCopy)
+		response = append(response, bookCopy)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func getCopiesForLibrarian(
+	ctx context.Context,
+	bookId string,
+) (response []*CopyInfoLibrarian, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        SELECT
+            c.copy_id AS "CopyId",
+            c.barcode AS "Barcode",
+            c.shelf_location AS "ShelfLocation",
+            c.copy_condition AS "Condition",
+            c.acquired_at AS "AcquiredAt",
+            c.copy_status AS "Status",
+            u.full_name AS "Borrower",
+            l.due_at AS "DueAt"
+        FROM book_copy c
+        LEFT JOIN loan l ON l.copy_id = c.copy_id AND l.returned_at IS NULL
+        LEFT JOIN library_user u ON u.user_id = l.member_id
+        WHERE c.book_id = $1
+        ORDER BY c.barcode`
+
+	rows, err := dbRunner.Query(ctx, query, bookId)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]*CopyInfoLibrarian, 0)
+	for rr.ScanNext() {
+		bookCopy := &CopyInfoLibrarian{}
+		rr.ReadAllToStruct(bookCopy)
+		response = append(response, bookCopy)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func updateCopy(
+	ctx context.Context,
+	copyId, barcode string,
+	shelfLocation, condition util.NullString,
+) (response time.Time, err error) {
+	query := `
+        UPDATE book_copy
+        SET
+            barcode = $1,
+            shelf_location = $2,
+            copy_condition = $3
+        WHERE copy_id = $4
+        RETURNING updated_at`
+
+	return executeQueryWithTimeResponse(
+		ctx,
+		query,
+		barcode,
+		shelfLocation,
+		condition,
+		copyId,
+	)
+}
+
+func deleteCopy(
+	ctx context.Context,
+	copyId string,
+) (response int64, err error) {
+	query := `
+        DELETE FROM book_copy
+        WHERE copy_id = $1
+        AND copy_status = $2`
+
+	return executeQueryWithRowsAffected(
+		ctx,
+		query,
+		copyId,
+		values.BookStatusAvailable,
+	)
+}
+
+func changeCopyStatus(
+	ctx context.Context,
+	copyId string,
+	status int,
+) (err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        UPDATE book_copy
+        SET copy_status = $1
+        WHERE copy_id = $2`
+
+	_, err = dbRunner.Exec(ctx, query, status, copyId)
+	return
+}
+
+func swapCopyStatus(
+	ctx context.Context,
+	copyId string,
+	expectedStatus, status int,
+) (response int64, err error) {
+	query := `
+        UPDATE book_copy
+        SET copy_status = $1
+        WHERE copy_id = $2
+        AND copy_status = $3`
+
+	return executeQueryWithRowsAffected(
+		ctx,
+		query,
+		status,
+		copyId,
+		expectedStatus,
+	)
+}
+
+func countAvailableCopies(
+	ctx context.Context,
+	bookId string,
+) (response int64, err error) {
+	query := `
+        SELECT COUNT(*)
+        FROM book_copy
+        WHERE book_id = $1
+        AND copy_status = $2`
+
+	return executeQueryWithInt64Response(
+		ctx,
+		query,
+		bookId,
+		values.BookStatusAvailable,
+	)
+}
+
+func countCopyCirculationRecords(
+	ctx context.Context,
+	copyId string,
+) (response int64, err error) {
+	query := `
+        SELECT
+            (SELECT COUNT(*) FROM loan WHERE copy_id = $1) +
+            (
+                SELECT COUNT(*)
+                FROM hold
+                WHERE copy_id = $1
+                AND hold_status IN ($2, $3)
+            )`
+
+	return executeQueryWithInt64Response(
+		ctx,
+		query,
+		copyId,
+		values.HoldStatusWaiting,
+		values.HoldStatusReady,
+	)
+}
@@ -0,0 +1,46 @@
+-- Replace book.borrower_id with a loan table which keeps the whole
+-- borrowing history of a book.
+
+-- loan
+CREATE TABLE loan (
+    loan_id uuid NOT NULL DEFAULT uuid_generate_v1mc(),
+    book_id uuid NOT NULL,
+    member_id uuid NOT NULL,
+    borrowed_at timestamp with time zone NOT NULL DEFAULT now(),
+    due_at timestamp with time zone NOT NULL,
+    returned_at timestamp with time zone,
+    CONSTRAINT loan_pk PRIMARY KEY (loan_id),
+    CONSTRAINT fk_loan_book_id FOREIGN KEY (book_id)
+        REFERENCES book (book_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE,
+    CONSTRAINT fk_loan_member_id FOREIGN KEY (member_id)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+);
+
+CREATE INDEX loan_book_id
+ON loan (book_id, borrowed_at);
+
+CREATE INDEX loan_member_id
+ON loan (member_id, borrowed_at);
+
+-- A book can only have one open loan at a time
+CREATE UNIQUE INDEX loan_open_book_id
+ON loan (book_id)
+WHERE returned_at IS NULL;
+
+CREATE INDEX loan_open_due_at
+ON loan (due_at)
+WHERE returned_at IS NULL;
+
+-- Move currently borrowed books into loans
+INSERT INTO loan (book_id, member_id, borrowed_at, due_at)
+SELECT book_id, borrower_id, updated_at, updated_at + interval '14 days'
+FROM book
+WHERE book_status = 2
+AND borrower_id IS NOT NULL;
+
+ALTER TABLE book DROP CONSTRAINT fk_book_borrower_id;
+ALTER TABLE book DROP COLUMN borrower_id;
@@ -0,0 +1,66 @@
+-- Split physical copies from bibliographic book records. The book table
+-- describes a title and every physical item owned by the library is a row
+-- in book_copy. Circulation status and loans move to the copies.
+
+-- book_copy
+CREATE TABLE book_copy (
+    copy_id uuid NOT NULL DEFAULT uuid_generate_v1mc(),
+    book_id uuid NOT NULL,
+    barcode text NOT NULL UNIQUE,
+    shelf_location text,
+    copy_condition text,
+    acquired_at date NOT NULL DEFAULT current_date,
+    copy_status integer DEFAULT 1,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT book_copy_pk PRIMARY KEY (copy_id),
+    CONSTRAINT fk_book_copy_book_id FOREIGN KEY (book_id)
+        REFERENCES book (book_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE,
+    CONSTRAINT fk_book_copy_copy_status FOREIGN KEY (copy_status)
+        REFERENCES enum_book_status (code) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+);
+
+CREATE INDEX book_copy_book_id_copy_status
+ON book_copy (book_id, copy_status);
+
+CREATE TRIGGER update_book_copy_updated_at_column
+    BEFORE UPDATE
+    ON book_copy
+    FOR EACH ROW
+    EXECUTE PROCEDURE update_updated_at_column();
+
+-- Every existing book becomes a single copy. The book id is used as the
+-- barcode until the items are relabeled.
+INSERT INTO book_copy (book_id, barcode, acquired_at, copy_status)
+SELECT book_id, book_id::text, created_at::date, book_status
+FROM book;
+
+-- loan
+ALTER TABLE loan ADD COLUMN copy_id uuid;
+
+UPDATE loan l
+SET copy_id = c.copy_id
+FROM book_copy c
+WHERE c.book_id = l.book_id;
+
+ALTER TABLE loan ALTER COLUMN copy_id SET NOT NULL;
+ALTER TABLE loan ADD CONSTRAINT fk_loan_copy_id FOREIGN KEY (copy_id)
+    REFERENCES book_copy (copy_id) MATCH SIMPLE
+    ON UPDATE NO ACTION
+    ON DELETE CASCADE;
+
+DROP INDEX loan_open_book_id;
+
+-- A copy can only have one open loan at a time
+CREATE UNIQUE INDEX loan_open_copy_id
+ON loan (copy_id)
+WHERE returned_at IS NULL;
+
+-- book
+ALTER TABLE book DROP CONSTRAINT fk_book_book_status;
+DROP INDEX book_book_status;
+ALTER TABLE book DROP COLUMN book_status;
@@ -0,0 +1,71 @@
+-- Hold queue for titles which have no available copies. When a copy is
+-- returned it is reserved for the first member in the queue who then has a
+-- limited time to pick it up.
+
+-- enum_book_status
+INSERT INTO enum_book_status
+VALUES
+    (3, 'reserved');
+
+-- enum_hold_status
+CREATE TABLE enum_hold_status (
+    code integer NOT NULL,
+    hold_status text NOT NULL,
+    CONSTRAINT enum_hold_status_pk PRIMARY KEY (code)
+);
+
+INSERT INTO enum_hold_status
+VALUES
+    (1, 'waiting'),
+    (2, 'ready'),
+    (3, 'fulfilled'),
+    (4, 'cancelled'),
+    (5, 'expired');
+
+-- hold
+CREATE TABLE hold (
+    hold_id uuid NOT NULL DEFAULT uuid_generate_v1mc(),
+    book_id uuid NOT NULL,
+    member_id uuid NOT NULL,
+    hold_status integer NOT NULL DEFAULT 1,
+    copy_id uuid,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    ready_at timestamp with time zone,
+    expires_at timestamp with time zone,
+    closed_at timestamp with time zone,
+    CONSTRAINT hold_pk PRIMARY KEY (hold_id),
+    CONSTRAINT fk_hold_book_id FOREIGN KEY (book_id)
+        REFERENCES book (book_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE,
+    CONSTRAINT fk_hold_member_id FOREIGN KEY (member_id)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE,
+    CONSTRAINT fk_hold_hold_status FOREIGN KEY (hold_status)
+        REFERENCES enum_hold_status (code) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION,
+    CONSTRAINT fk_hold_copy_id FOREIGN KEY (copy_id)
+        REFERENCES book_copy (copy_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE SET NULL
+);
+
+-- The queue of a title is read in creation order
+CREATE INDEX hold_book_id_created_at
+ON hold (book_id, created_at)
+WHERE hold_status = 1;
+
+CREATE INDEX hold_member_id
+ON hold (member_id, created_at);
+
+-- A member can only have one active hold per title
+CREATE UNIQUE INDEX hold_active_book_id_member_id
+ON hold (book_id, member_id)
+WHERE hold_status IN (1, 2);
+
+-- A copy can only be reserved for one hold at a time
+CREATE UNIQUE INDEX hold_ready_copy_id
+ON hold (copy_id)
+WHERE hold_status = 2;
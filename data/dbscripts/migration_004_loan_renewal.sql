@@ -0,0 +1,27 @@
+-- Loan renewals. Every renewal is recorded so that the original due date
+-- and who extended it can be traced.
+
+-- loan
+ALTER TABLE loan ADD COLUMN renewal_count integer NOT NULL DEFAULT 0;
+
+-- loan_renewal
+CREATE TABLE loan_renewal (
+    renewal_id uuid NOT NULL DEFAULT uuid_generate_v1mc(),
+    loan_id uuid NOT NULL,
+    renewed_by uuid NOT NULL,
+    renewed_at timestamp with time zone NOT NULL DEFAULT now(),
+    previous_due_at timestamp with time zone NOT NULL,
+    new_due_at timestamp with time zone NOT NULL,
+    CONSTRAINT loan_renewal_pk PRIMARY KEY (renewal_id),
+    CONSTRAINT fk_loan_renewal_loan_id FOREIGN KEY (loan_id)
+        REFERENCES loan (loan_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE,
+    CONSTRAINT fk_loan_renewal_renewed_by FOREIGN KEY (renewed_by)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+);
+
+CREATE INDEX loan_renewal_loan_id
+ON loan_renewal (loan_id, renewed_at);
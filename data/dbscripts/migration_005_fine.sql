@@ -0,0 +1,48 @@
+-- Fines ledger. Amounts are stored in cents. The balance of a member is the
+-- sum of charges minus the sum of payments and waivers.
+
+-- enum_fine_entry_type
+CREATE TABLE enum_fine_entry_type (
+    code integer NOT NULL,
+    entry_type text NOT NULL,
+    CONSTRAINT enum_fine_entry_type_pk PRIMARY KEY (code)
+);
+
+INSERT INTO enum_fine_entry_type
+VALUES
+    (1, 'charge'),
+    (2, 'payment'),
+    (3, 'waiver');
+
+-- fine_entry
+CREATE TABLE fine_entry (
+    entry_id uuid NOT NULL DEFAULT uuid_generate_v1mc(),
+    member_id uuid NOT NULL,
+    loan_id uuid,
+    entry_type integer NOT NULL,
+    amount bigint NOT NULL,
+    note text,
+    created_by uuid,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT fine_entry_pk PRIMARY KEY (entry_id),
+    CONSTRAINT fine_entry_amount_positive CHECK (amount > 0),
+    CONSTRAINT fk_fine_entry_member_id FOREIGN KEY (member_id)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION,
+    CONSTRAINT fk_fine_entry_loan_id FOREIGN KEY (loan_id)
+        REFERENCES loan (loan_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE SET NULL,
+    CONSTRAINT fk_fine_entry_entry_type FOREIGN KEY (entry_type)
+        REFERENCES enum_fine_entry_type (code) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION,
+    CONSTRAINT fk_fine_entry_created_by FOREIGN KEY (created_by)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE SET NULL
+);
+
+CREATE INDEX fine_entry_member_id
+ON fine_entry (member_id, created_at);
@@ -0,0 +1,7 @@
+-- Member categories and membership expiry used by the borrowing
+-- eligibility rules. A NULL expiry means the membership does not expire.
+
+-- library_user
+ALTER TABLE library_user ADD COLUMN member_category text;
+ALTER TABLE library_user
+    ADD COLUMN membership_expires_at timestamp with time zone;
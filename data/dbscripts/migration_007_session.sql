@@ -0,0 +1,25 @@
+-- Login sessions which replace the permanent token of a user. A token is
+-- valid until its session expires or is revoked.
+
+-- session
+CREATE TABLE session (
+    session_id uuid NOT NULL DEFAULT uuid_generate_v1mc(),
+    user_id uuid NOT NULL,
+    token uuid NOT NULL DEFAULT uuid_generate_v4(),
+    issued_at timestamp with time zone NOT NULL DEFAULT now(),
+    expires_at timestamp with time zone NOT NULL,
+    revoked_at timestamp with time zone,
+    CONSTRAINT session_pk PRIMARY KEY (session_id),
+    CONSTRAINT session_token_key UNIQUE (token),
+    CONSTRAINT fk_session_user_id FOREIGN KEY (user_id)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+);
+
+CREATE INDEX session_user_id
+ON session (user_id)
+WHERE revoked_at IS NULL;
+
+-- library_user
+ALTER TABLE library_user DROP COLUMN token;
@@ -0,0 +1,44 @@
+-- Account status of a user. Self-registered members stay pending until a
+-- librarian approves or rejects them, and only active users can log in.
+
+-- enum_user_role
+-- The primary key of the role table was named after the status table
+ALTER TABLE enum_user_role
+    RENAME CONSTRAINT enum_user_status_pk TO enum_user_role_pk;
+
+-- enum_user_status
+CREATE TABLE enum_user_status (
+    code integer NOT NULL,
+    user_status text NOT NULL,
+    CONSTRAINT enum_user_status_pk PRIMARY KEY (code)
+);
+
+INSERT INTO enum_user_status
+VALUES
+    (1, 'active'),
+    (2, 'pending'),
+    (3, 'rejected');
+
+-- library_user
+ALTER TABLE library_user
+    ADD COLUMN user_status integer NOT NULL DEFAULT 1;
+ALTER TABLE library_user
+    ADD COLUMN created_at timestamp with time zone NOT NULL DEFAULT now();
+ALTER TABLE library_user ADD COLUMN reviewed_by uuid;
+ALTER TABLE library_user
+    ADD COLUMN reviewed_at timestamp with time zone;
+
+ALTER TABLE library_user
+    ADD CONSTRAINT fk_library_user_user_status FOREIGN KEY (user_status)
+        REFERENCES enum_user_status (code) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION;
+ALTER TABLE library_user
+    ADD CONSTRAINT fk_library_user_reviewed_by FOREIGN KEY (reviewed_by)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE SET NULL;
+
+CREATE INDEX library_user_pending_created_at
+ON library_user (created_at)
+WHERE user_status = 2;
@@ -0,0 +1,48 @@
+-- Suspension of users and deletion of users by librarians. A user can only
+-- be deleted without open loans, their loan history and fines are removed
+-- with them.
+
+-- enum_user_status
+INSERT INTO enum_user_status
+VALUES
+    (4, 'suspended');
+
+-- library_user
+ALTER TABLE library_user ADD COLUMN suspension_reason text;
+ALTER TABLE library_user
+    ADD COLUMN suspended_at timestamp with time zone;
+ALTER TABLE library_user ADD COLUMN suspended_by uuid;
+
+ALTER TABLE library_user
+    ADD CONSTRAINT fk_library_user_suspended_by FOREIGN KEY (suspended_by)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE SET NULL;
+
+-- loan
+ALTER TABLE loan DROP CONSTRAINT fk_loan_member_id;
+ALTER TABLE loan
+    ADD CONSTRAINT fk_loan_member_id FOREIGN KEY (member_id)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE;
+
+-- loan_renewal
+ALTER TABLE loan_renewal ALTER COLUMN renewed_by DROP NOT NULL;
+ALTER TABLE loan_renewal DROP CONSTRAINT fk_loan_renewal_renewed_by;
+ALTER TABLE loan_renewal
+    ADD CONSTRAINT fk_loan_renewal_renewed_by FOREIGN KEY (renewed_by)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE SET NULL;
+
+-- fine_entry
+ALTER TABLE fine_entry DROP CONSTRAINT fk_fine_entry_member_id;
+ALTER TABLE fine_entry
+    ADD CONSTRAINT fk_fine_entry_member_id FOREIGN KEY (member_id)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE;
+
+CREATE INDEX library_user_full_name
+ON library_user (full_name);
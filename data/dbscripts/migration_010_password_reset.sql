@@ -0,0 +1,26 @@
+-- Single use tokens for resetting a forgotten password. Only a hash of the
+-- token is stored.
+
+-- library_user
+ALTER TABLE library_user
+    ADD COLUMN password_changed_at timestamp with time zone;
+
+-- password_reset
+CREATE TABLE password_reset (
+    reset_id uuid NOT NULL DEFAULT uuid_generate_v1mc(),
+    user_id uuid NOT NULL,
+    token_hash text NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone,
+    CONSTRAINT password_reset_pk PRIMARY KEY (reset_id),
+    CONSTRAINT password_reset_token_hash_key UNIQUE (token_hash),
+    CONSTRAINT fk_password_reset_user_id FOREIGN KEY (user_id)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+);
+
+CREATE INDEX password_reset_user_id
+ON password_reset (user_id)
+WHERE used_at IS NULL;
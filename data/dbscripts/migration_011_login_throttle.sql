@@ -0,0 +1,11 @@
+-- Failed login attempts per username and per client IP. Repeated failures
+-- slow down further attempts and eventually lock the key for a while.
+
+-- login_throttle
+CREATE TABLE login_throttle (
+    throttle_key text NOT NULL,
+    failed_attempts integer NOT NULL DEFAULT 0,
+    last_failed_at timestamp with time zone NOT NULL DEFAULT now(),
+    locked_until timestamp with time zone,
+    CONSTRAINT login_throttle_pk PRIMARY KEY (throttle_key)
+);
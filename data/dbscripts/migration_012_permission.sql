@@ -0,0 +1,68 @@
+-- Permissions which roles grant to their users. Roles are managed through
+-- the admin API and the handlers check permissions instead of roles.
+
+-- enum_user_role
+ALTER TABLE enum_user_role RENAME COLUMN book_status TO user_role;
+ALTER TABLE enum_user_role
+    ADD CONSTRAINT enum_user_role_user_role_key UNIQUE (user_role);
+
+INSERT INTO enum_user_role
+VALUES
+    (3, 'admin'),
+    (4, 'circulation_desk'),
+    (5, 'cataloguer');
+
+-- permission
+CREATE TABLE permission (
+    permission text NOT NULL,
+    permission_description text NOT NULL,
+    CONSTRAINT permission_pk PRIMARY KEY (permission)
+);
+
+INSERT INTO permission
+VALUES
+    ('book:read', 'Browse books and copies'),
+    ('book:write', 'Create, update and delete books and copies'),
+    ('loan:self', 'Borrow, return, renew and hold books for oneself'),
+    ('loan:checkout', 'Check copies out and in for members and view loans'),
+    ('fine:manage', 'View fines of members and record payments and waivers'),
+    ('user:manage', 'Manage users and registrations'),
+    ('role:manage', 'Manage roles and their permissions');
+
+-- role_permission
+CREATE TABLE role_permission (
+    user_role integer NOT NULL,
+    permission text NOT NULL,
+    CONSTRAINT role_permission_pk PRIMARY KEY (user_role, permission),
+    CONSTRAINT fk_role_permission_user_role FOREIGN KEY (user_role)
+        REFERENCES enum_user_role (code) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE,
+    CONSTRAINT fk_role_permission_permission FOREIGN KEY (permission)
+        REFERENCES permission (permission) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+);
+
+INSERT INTO role_permission
+VALUES
+    (1, 'book:read'),
+    (1, 'loan:self'),
+    (2, 'book:read'),
+    (2, 'book:write'),
+    (2, 'loan:self'),
+    (2, 'loan:checkout'),
+    (2, 'fine:manage'),
+    (2, 'user:manage'),
+    (3, 'book:read'),
+    (3, 'book:write'),
+    (3, 'loan:self'),
+    (3, 'loan:checkout'),
+    (3, 'fine:manage'),
+    (3, 'user:manage'),
+    (3, 'role:manage'),
+    (4, 'book:read'),
+    (4, 'loan:checkout'),
+    (4, 'fine:manage'),
+    (5, 'book:read'),
+    (5, 'book:write');
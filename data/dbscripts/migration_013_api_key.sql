@@ -0,0 +1,48 @@
+-- Named API keys for machine clients. Only a hash of the key is stored, the
+-- key itself is shown once when it is created. A key acts on behalf of the
+-- user who created it but is restricted to its own permissions and
+-- optionally to a list of client IPs or networks.
+
+-- permission
+INSERT INTO permission
+VALUES
+    ('apikey:manage', 'Create, list and revoke API keys');
+
+INSERT INTO role_permission
+VALUES
+    (2, 'apikey:manage'),
+    (3, 'apikey:manage');
+
+-- api_key
+CREATE TABLE api_key (
+    key_id uuid NOT NULL DEFAULT uuid_generate_v1mc(),
+    key_name text NOT NULL,
+    key_prefix text NOT NULL,
+    key_hash text NOT NULL,
+    allowed_ips text NOT NULL DEFAULT '',
+    created_by uuid,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    last_used_at timestamp with time zone,
+    revoked_at timestamp with time zone,
+    CONSTRAINT api_key_pk PRIMARY KEY (key_id),
+    CONSTRAINT api_key_key_hash_key UNIQUE (key_hash),
+    CONSTRAINT fk_api_key_created_by FOREIGN KEY (created_by)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE SET NULL
+);
+
+-- api_key_permission
+CREATE TABLE api_key_permission (
+    key_id uuid NOT NULL,
+    permission text NOT NULL,
+    CONSTRAINT api_key_permission_pk PRIMARY KEY (key_id, permission),
+    CONSTRAINT fk_api_key_permission_key_id FOREIGN KEY (key_id)
+        REFERENCES api_key (key_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE,
+    CONSTRAINT fk_api_key_permission_permission FOREIGN KEY (permission)
+        REFERENCES permission (permission) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE
+);
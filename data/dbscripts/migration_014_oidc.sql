@@ -0,0 +1,20 @@
+-- Login through an OpenID Connect provider. A user is linked to the subject
+-- which the provider issued for them, and pending logins are kept until the
+-- provider redirects back.
+
+-- library_user
+ALTER TABLE library_user
+    ADD COLUMN oidc_issuer text,
+    ADD COLUMN oidc_subject text,
+    ADD CONSTRAINT library_user_oidc_subject_key
+        UNIQUE (oidc_issuer, oidc_subject);
+
+-- oidc_login
+CREATE TABLE oidc_login (
+    login_state text NOT NULL,
+    nonce text NOT NULL,
+    code_verifier text NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    expires_at timestamp with time zone NOT NULL,
+    CONSTRAINT oidc_login_pk PRIMARY KEY (login_state)
+);
@@ -0,0 +1,18 @@
+-- Loans and fines of a member are kept as circulation records. A member who
+-- has any of them can't be deleted and is suspended instead.
+
+-- loan
+ALTER TABLE loan DROP CONSTRAINT fk_loan_member_id;
+ALTER TABLE loan
+    ADD CONSTRAINT fk_loan_member_id FOREIGN KEY (member_id)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE RESTRICT;
+
+-- fine_entry
+ALTER TABLE fine_entry DROP CONSTRAINT fk_fine_entry_member_id;
+ALTER TABLE fine_entry
+    ADD CONSTRAINT fk_fine_entry_member_id FOREIGN KEY (member_id)
+        REFERENCES library_user (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE RESTRICT;
@@ -0,0 +1,18 @@
+-- Loans are kept as circulation records when their book or copy would be
+-- deleted, like the loans of members since migration 015. A book or copy
+-- which has loans can't be deleted anymore.
+
+-- loan
+ALTER TABLE loan DROP CONSTRAINT fk_loan_book_id;
+ALTER TABLE loan
+    ADD CONSTRAINT fk_loan_book_id FOREIGN KEY (book_id)
+        REFERENCES book (book_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE RESTRICT;
+
+ALTER TABLE loan DROP CONSTRAINT fk_loan_copy_id;
+ALTER TABLE loan
+    ADD CONSTRAINT fk_loan_copy_id FOREIGN KEY (copy_id)
+        REFERENCES book_copy (copy_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE RESTRICT;
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Add a charge, payment or waiver to the fine ledger of a member
+	CreateFineEntry = createFineEntry
+
+	// Return the outstanding fine balance of a member in cents
+	GetFineBalance = getFineBalance
+
+	// Return the fine ledger of a member
+	GetFineEntries = getFineEntries
+)
+
+// This struct contains all database columns converted to Go types
+type FineEntryEntity struct {
+	EntryId   string
+	MemberId  string
+	LoanId    string `json:",omitempty"`
+	Type      int
+	Amount    int64
+	Note      string `json:",omitempty"`
+	CreatedBy string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+func createFineEntry(
+	ctx context.Context,
+	memberId string,
+	loanId util.NullString,
+	entryType int,
+	amount int64,
+	note, createdBy util.NullString,
+) (response *FineEntryEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        INSERT INTO fine_entry (
+            member_id, loan_id, entry_type, amount, note, created_by
+        )
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING entry_id, created_at`
+
+	rows, err := dbRunner.Query(
+		ctx,
+		query,
+		memberId,
+		loanId,
+		entryType,
+		amount,
+		note,
+		createdBy,
+	)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &FineEntryEntity{
+			EntryId:   rr.ReadByIdxString(0),
+			MemberId:  memberId,
+			LoanId:    util.GetNullStringValue(loanId),
+			Type:      entryType,
+			Amount:    amount,
+			Note:      util.GetNullStringValue(note),
+			CreatedBy: util.GetNullStringValue(createdBy),
+			CreatedAt: rr.ReadByIdxTime(1),
+		}
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func getFineBalance(
+	ctx context.Context,
+	memberId string,
+) (response int64, err error) {
+	query := `
+        SELECT COALESCE(SUM(
+            CASE WHEN entry_type = $2 THEN amount ELSE -amount END
+        ), 0)
+        FROM fine_entry
+        WHERE member_id = $1`
+
+	return executeQueryWithInt64Response(
+		ctx,
+		query,
+		memberId,
+		values.FineEntryTypeCharge,
+	)
+}
+
+func getFineEntries(
+	ctx context.Context,
+	memberId string,
+	rowOffset, rowLimit int,
+) (response []*FineEntryEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        SELECT
+            entry_id AS "EntryId",
+            member_id AS "MemberId",
+            loan_id AS "LoanId",
+            entry_type AS "Type",
+            amount AS "Amount",
+            note AS "Note",
+            created_by AS "CreatedBy",
+            created_at AS "CreatedAt"
+        FROM fine_entry
+        WHERE member_id = $1
+        ORDER BY created_at DESC
+        OFFSET $2
+        LIMIT $3`
+
+	rows, err := dbRunner.Query(ctx, query, memberId, rowOffset, rowLimit)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]*FineEntryEntity, 0)
+	for rr.ScanNext() {
+		entry := &FineEntryEntity{}
+		rr.ReadAllToStruct(entry)
+		response = append(response, entry)
+	}
+
+	err = rr.Error()
+
+	return
+}
@@ -0,0 +1,353 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Place a member into the hold queue of a book
+	CreateHold = createHold
+
+	// Retrieve a hold
+	GetHold = getHold
+
+	// Return the waiting or ready hold of a member for a book
+	GetActiveHold = getActiveHold
+
+	// Lock and return the first waiting hold in the queue of a book. Must be
+	// called within a transaction.
+	GetNextWaitingHold = getNextWaitingHold
+
+	// Return the hold which a copy is reserved for
+	GetReadyHoldForCopy = getReadyHoldForCopy
+
+	// Return ready holds which were not picked up in time
+	GetExpiredHolds = getExpiredHolds
+
+	// Return all active and past holds of a member
+	GetHoldsForMember = getHoldsForMember
+
+	// Reserve a copy for a hold
+	ReadyHold = readyHold
+
+	// Close a hold with the given status only if it still has the expected
+	// status. Returns the number of changed rows.
+	CloseHold = closeHold
+
+	// Return the number of members waiting in the queue of a book
+	CountWaitingHolds = countWaitingHolds
+)
+
+// This struct contains all database columns converted to Go types
+type HoldEntity struct {
+	HoldId    string
+	BookId    string
+	MemberId  string
+	Status    int
+	CopyId    string `json:",omitempty"`
+	CreatedAt time.Time
+	ReadyAt   *time.Time `json:",omitempty"`
+	ExpiresAt *time.Time `json:",omitempty"`
+	ClosedAt  *time.Time `json:",omitempty"`
+}
+
+// Struct which is used when members queries for their holds
+type HoldInfo struct {
+	HoldId        string
+	BookId        string
+	BookName      string
+	Status        int64
+	QueuePosition int64  `json:",omitempty"`
+	CopyId        string `json:",omitempty"`
+	Barcode       string `json:",omitempty"`
+	CreatedAt     time.Time
+	ExpiresAt     *time.Time `json:",omitempty"`
+}
+
+const holdEntityColumns = `
+            hold_id AS "HoldId",
+            book_id AS "BookId",
+            member_id AS "MemberId",
+            hold_status AS "Status",
+            copy_id AS "CopyId",
+            created_at AS "CreatedAt",
+            ready_at AS "ReadyAt",
+            expires_at AS "ExpiresAt",
+            closed_at AS "ClosedAt"`
+
+func createHold(
+	ctx context.Context,
+	bookId, memberId string,
+) (response *HoldEntity, err error) {
+	query := `
+        INSERT INTO hold (book_id, member_id)
+        VALUES ($1, $2)
+        RETURNING` + holdEntityColumns
+
+	return queryHold(ctx, query, bookId, memberId)
+}
+
+func getHold(
+	ctx context.Context,
+	holdId string,
+) (response *HoldEntity, err error) {
+	query := `
+        SELECT` + holdEntityColumns + `
+        FROM hold
+        WHERE hold_id = $1`
+
+	return queryHold(ctx, query, holdId)
+}
+
+func getActiveHold(
+	ctx context.Context,
+	bookId, memberId string,
+) (response *HoldEntity, err error) {
+	query := `
+        SELECT` + holdEntityColumns + `
+        FROM hold
+        WHERE book_id = $1
+        AND member_id = $2
+        AND hold_status IN ($3, $4)`
+
+	return queryHold(
+		ctx,
+		query,
+		bookId,
+		memberId,
+		values.HoldStatusWaiting,
+		values.HoldStatusReady,
+	)
+}
+
+func getNextWaitingHold(
+	ctx context.Context,
+	bookId string,
+) (response *HoldEntity, err error) {
+	query := `
+        SELECT` + holdEntityColumns + `
+        FROM hold
+        WHERE book_id = $1
+        AND hold_status = $2
+        ORDER BY created_at
+        LIMIT 1
+        FOR UPDATE`
+
+	for {
+		response, err = queryHold(ctx, query, bookId, values.HoldStatusWaiting)
+		if err != nil || response != nil {
+			return
+		}
+
+		// The first hold is skipped without a row when another transaction
+		// changed it while this one waited for its lock, even though more
+		// members may be waiting
+		var waiting int64
+		waiting, err = countWaitingHolds(ctx, bookId)
+		if err != nil || waiting == 0 {
+			return
+		}
+	}
+}
+
+func getReadyHoldForCopy(
+	ctx context.Context,
+	copyId string,
+) (response *HoldEntity, err error) {
+	query := `
+        SELECT` + holdEntityColumns + `
+        FROM hold
+        WHERE copy_id = $1
+        AND hold_status = $2`
+
+	return queryHold(ctx, query, copyId, values.HoldStatusReady)
+}
+
+func getExpiredHolds(
+	ctx context.Context,
+) (response []*HoldEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        SELECT` + holdEntityColumns + `
+        FROM hold
+        WHERE hold_status = $1
+        AND expires_at < now()
+        ORDER BY expires_at`
+
+	rows, err := dbRunner.Query(ctx, query, values.HoldStatusReady)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]*HoldEntity, 0)
+	for rr.ScanNext() {
+		hold := &HoldEntity{}
+		rr.ReadAllToStruct(hold)
+		response = append(response, hold)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func getHoldsForMember(
+	ctx context.Context,
+	memberId string,
+) (response []*HoldInfo, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        SELECT
+            h.hold_id AS "HoldId",
+            h.book_id AS "BookId",
+            b.book_name AS "BookName",
+            h.hold_status AS "Status",
+            CASE WHEN h.hold_status = $2 THEN (
+                SELECT COUNT(*)
+                FROM hold q
+                WHERE q.book_id = h.book_id
+                AND q.hold_status = $2
+                AND q.created_at <= h.created_at
+            ) END AS "QueuePosition",
+            h.copy_id AS "CopyId",
+            c.barcode AS "Barcode",
+            h.created_at AS "CreatedAt",
+            h.expires_at AS "ExpiresAt"
+        FROM hold h
+        JOIN book b ON b.book_id = h.book_id
+        LEFT JOIN book_copy c ON c.copy_id = h.copy_id
+        WHERE h.member_id = $1
+        ORDER BY h.created_at DESC`
+
+	rows, err := dbRunner.Query(ctx, query, memberId, values.HoldStatusWaiting)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]*HoldInfo, 0)
+	for rr.ScanNext() {
+		hold := &HoldInfo{}
+		rr.ReadAllToStruct(hold)
+		response = append(response, hold)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func readyHold(
+	ctx context.Context,
+	holdId, copyId string,
+	expiresAt time.Time,
+) (err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        UPDATE hold
+        SET
+            hold_status = $1,
+            copy_id = $2,
+            ready_at = now(),
+            expires_at = $3
+        WHERE hold_id = $4`
+
+	_, err = dbRunner.Exec(
+		ctx,
+		query,
+		values.HoldStatusReady,
+		copyId,
+		expiresAt,
+		holdId,
+	)
+	return
+}
+
+func closeHold(
+	ctx context.Context,
+	holdId string,
+	oldStatus, newStatus int,
+) (response int64, err error) {
+	query := `
+        UPDATE hold
+        SET
+            hold_status = $1,
+            closed_at = now()
+        WHERE hold_id = $2
+        AND hold_status = $3`
+
+	return executeQueryWithRowsAffected(
+		ctx,
+		query,
+		newStatus,
+		holdId,
+		oldStatus,
+	)
+}
+
+func countWaitingHolds(
+	ctx context.Context,
+	bookId string,
+) (response int64, err error) {
+	query := `
+        SELECT COUNT(*)
+        FROM hold
+        WHERE book_id = $1
+        AND hold_status = $2`
+
+	return executeQueryWithInt64Response(
+		ctx,
+		query,
+		bookId,
+		values.HoldStatusWaiting,
+	)
+}
+
+func queryHold(
+	ctx context.Context,
+	query string,
+	params ...interface{},
+) (response *HoldEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	rows, err := dbRunner.Query(ctx, query, params...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &HoldEntity{}
+		rr.ReadAllToStruct(response)
+	}
+
+	err = rr.Error()
+
+	return
+}
@@ -0,0 +1,422 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Create a new loan for a copy
+	CreateLoan = createLoan
+
+	// Return the open loan of a copy, nil if the copy is not borrowed
+	GetOpenLoanForCopy = getOpenLoanForCopy
+
+	// Mark a loan as returned
+	CloseLoan = closeLoan
+
+	// Return the loan history of a book
+	GetLoansForBook = getLoansForBook
+
+	// Return all loans which are past their due date
+	GetOverdueLoans = getOverdueLoans
+
+	// Retrieve a loan
+	GetLoan = getLoan
+
+	// Lock a loan for the rest of the transaction and return it
+	LockLoan = lockLoan
+
+	// Return the most recent loan of a copy
+	GetLatestLoanForCopy = getLatestLoanForCopy
+
+	// Return the open loans of a member
+	GetOpenLoansForMember = getOpenLoansForMember
+
+	// Return the returned loans of a member
+	GetLoanHistoryForMember = getLoanHistoryForMember
+
+	// Move the due date of an open loan and record the renewal
+	RenewLoan = renewLoan
+)
+
+// This struct contains all database columns converted to Go types
+type LoanEntity struct {
+	LoanId       string
+	CopyId       string
+	BookId       string
+	MemberId     string
+	BorrowedAt   time.Time
+	DueAt        time.Time
+	ReturnedAt   *time.Time `json:",omitempty"`
+	RenewalCount int
+}
+
+// Struct which is used when querying for loans
+type LoanInfo struct {
+	LoanId       string
+	CopyId       string
+	Barcode      string
+	BookId       string
+	BookName     string
+	MemberId     string
+	MemberName   string
+	BorrowedAt   time.Time
+	DueAt        time.Time
+	ReturnedAt   *time.Time `json:",omitempty"`
+	RenewalCount int64
+}
+
+func createLoan(
+	ctx context.Context,
+	copyId, bookId, memberId string,
+	dueAt time.Time,
+) (response *LoanEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        INSERT INTO loan (copy_id, book_id, member_id, due_at)
+        VALUES ($1, $2, $3, $4)
+        RETURNING loan_id, borrowed_at`
+
+	rows, err := dbRunner.Query(ctx, query, copyId, bookId, memberId, dueAt)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &LoanEntity{
+			LoanId:     rr.ReadByIdxString(0),
+			CopyId:     copyId,
+			BookId:     bookId,
+			MemberId:   memberId,
+			BorrowedAt: rr.ReadByIdxTime(1),
+			DueAt:      dueAt,
+		}
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func getOpenLoanForCopy(
+	ctx context.Context,
+	copyId string,
+) (response *LoanEntity, err error) {
+	query := `
+        SELECT
+            loan_id AS "LoanId",
+            copy_id AS "CopyId",
+            book_id AS "BookId",
+            member_id AS "MemberId",
+            borrowed_at AS "BorrowedAt",
+            due_at AS "DueAt",
+            renewal_count AS "RenewalCount"
+        FROM loan
+        WHERE copy_id = $1
+        AND returned_at IS NULL`
+
+	return queryLoan(ctx, query, copyId)
+}
+
+func getLoan(
+	ctx context.Context,
+	loanId string,
+) (response *LoanEntity, err error) {
+	query := `
+        SELECT
+            loan_id AS "LoanId",
+            copy_id AS "CopyId",
+            book_id AS "BookId",
+            member_id AS "MemberId",
+            borrowed_at AS "BorrowedAt",
+            due_at AS "DueAt",
+            returned_at AS "ReturnedAt",
+            renewal_count AS "RenewalCount"
+        FROM loan
+        WHERE loan_id = $1`
+
+	return queryLoan(ctx, query, loanId)
+}
+
+func lockLoan(
+	ctx context.Context,
+	loanId string,
+) (response *LoanEntity, err error) {
+	query := `
+        SELECT
+            loan_id AS "LoanId",
+            copy_id AS "CopyId",
+            book_id AS "BookId",
+            member_id AS "MemberId",
+            borrowed_at AS "BorrowedAt",
+            due_at AS "DueAt",
+            returned_at AS "ReturnedAt",
+            renewal_count AS "RenewalCount"
+        FROM loan
+        WHERE loan_id = $1
+        FOR UPDATE`
+
+	return queryLoan(ctx, query, loanId)
+}
+
+func getLatestLoanForCopy(
+	ctx context.Context,
+	copyId string,
+) (response *LoanEntity, err error) {
+	query := `
+        SELECT
+            loan_id AS "LoanId",
+            copy_id AS "CopyId",
+            book_id AS "BookId",
+            member_id AS "MemberId",
+            borrowed_at AS "BorrowedAt",
+            due_at AS "DueAt",
+            returned_at AS "ReturnedAt",
+            renewal_count AS "RenewalCount"
+        FROM loan
+        WHERE copy_id = $1
+        ORDER BY borrowed_at DESC
+        LIMIT 1`
+
+	return queryLoan(ctx, query, copyId)
+}
+
+func queryLoan(
+	ctx context.Context,
+	query string,
+	params ...interface{},
+) (response *LoanEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	rows, err := dbRunner.Query(ctx, query, params...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &LoanEntity{}
+		rr.ReadAllToStruct(response)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func closeLoan(
+	ctx context.Context,
+	loanId string,
+) (response time.Time, err error) {
+	query := `
+        UPDATE loan
+        SET returned_at = now()
+        WHERE loan_id = $1
+        AND returned_at IS NULL
+        RETURNING returned_at`
+
+	return executeQueryWithTimeResponse(ctx, query, loanId)
+}
+
+func renewLoan(
+	ctx context.Context,
+	loanId, renewedBy string,
+	previousDueAt, newDueAt time.Time,
+) (err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        UPDATE loan
+        SET
+            due_at = $1,
+            renewal_count = renewal_count + 1
+        WHERE loan_id = $2
+        AND returned_at IS NULL`
+
+	_, err = dbRunner.Exec(ctx, query, newDueAt, loanId)
+	if err != nil {
+		return
+	}
+
+	query = `
+        INSERT INTO loan_renewal (
+            loan_id, renewed_by, previous_due_at, new_due_at
+        )
+        VALUES ($1, $2, $3, $4)`
+
+	_, err = dbRunner.Exec(
+		ctx,
+		query,
+		loanId,
+		renewedBy,
+		previousDueAt,
+		newDueAt,
+	)
+	return
+}
+
+func getLoansForBook(
+	ctx context.Context,
+	bookId string,
+	rowOffset, rowLimit int,
+) (response []*LoanInfo, err error) {
+	query := `
+        SELECT
+            l.loan_id AS "LoanId",
+            l.copy_id AS "CopyId",
+            c.barcode AS "Barcode",
+            l.book_id AS "BookId",
+            b.book_name AS "BookName",
+            l.member_id AS "MemberId",
+            u.full_name AS "MemberName",
+            l.borrowed_at AS "BorrowedAt",
+            l.due_at AS "DueAt",
+            l.returned_at AS "ReturnedAt",
+            l.renewal_count AS "RenewalCount"
+        FROM loan l
+        JOIN book_copy c ON c.copy_id = l.copy_id
+        JOIN book b ON b.book_id = l.book_id
+        JOIN library_user u ON u.user_id = l.member_id
+        WHERE l.book_id = $1
+        ORDER BY l.borrowed_at DESC
+        OFFSET $2
+        LIMIT $3`
+
+	return queryLoanInfos(ctx, query, bookId, rowOffset, rowLimit)
+}
+
+func getOverdueLoans(
+	ctx context.Context,
+	rowOffset, rowLimit int,
+) (response []*LoanInfo, err error) {
+	query := `
+        SELECT
+            l.loan_id AS "LoanId",
+            l.copy_id AS "CopyId",
+            c.barcode AS "Barcode",
+            l.book_id AS "BookId",
+            b.book_name AS "BookName",
+            l.member_id AS "MemberId",
+            u.full_name AS "MemberName",
+            l.borrowed_at AS "BorrowedAt",
+            l.due_at AS "DueAt",
+            l.renewal_count AS "RenewalCount"
+        FROM loan l
+        JOIN book_copy c ON c.copy_id = l.copy_id
+        JOIN book b ON b.book_id = l.book_id
+        JOIN library_user u ON u.user_id = l.member_id
+        WHERE l.returned_at IS NULL
+        AND l.due_at < now()
+        ORDER BY l.due_at
+        OFFSET $1
+        LIMIT $2`
+
+	return queryLoanInfos(ctx, query, rowOffset, rowLimit)
+}
+
+func getOpenLoansForMember(
+	ctx context.Context,
+	memberId string,
+) (response []*LoanInfo, err error) {
+	query := `
+        SELECT
+            l.loan_id AS "LoanId",
+            l.copy_id AS "CopyId",
+            c.barcode AS "Barcode",
+            l.book_id AS "BookId",
+            b.book_name AS "BookName",
+            l.member_id AS "MemberId",
+            u.full_name AS "MemberName",
+            l.borrowed_at AS "BorrowedAt",
+            l.due_at AS "DueAt",
+            l.renewal_count AS "RenewalCount"
+        FROM loan l
+        JOIN book_copy c ON c.copy_id = l.copy_id
+        JOIN book b ON b.book_id = l.book_id
+        JOIN library_user u ON u.user_id = l.member_id
+        WHERE l.member_id = $1
+        AND l.returned_at IS NULL
+        ORDER BY l.due_at`
+
+	return queryLoanInfos(ctx, query, memberId)
+}
+
+func getLoanHistoryForMember(
+	ctx context.Context,
+	memberId string,
+	rowOffset, rowLimit int,
+) (response []*LoanInfo, err error) {
+	query := `
+        SELECT
+            l.loan_id AS "LoanId",
+            l.copy_id AS "CopyId",
+            c.barcode AS "Barcode",
+            l.book_id AS "BookId",
+            b.book_name AS "BookName",
+            l.member_id AS "MemberId",
+            u.full_name AS "MemberName",
+            l.borrowed_at AS "BorrowedAt",
+            l.due_at AS "DueAt",
+            l.returned_at AS "ReturnedAt",
+            l.renewal_count AS "RenewalCount"
+        FROM loan l
+        JOIN book_copy c ON c.copy_id = l.copy_id
+        JOIN book b ON b.book_id = l.book_id
+        JOIN library_user u ON u.user_id = l.member_id
+        WHERE l.member_id = $1
+        AND l.returned_at IS NOT NULL
+        ORDER BY l.returned_at DESC
+        OFFSET $2
+        LIMIT $3`
+
+	return queryLoanInfos(ctx, query, memberId, rowOffset, rowLimit)
+}
+
+func queryLoanInfos(
+	ctx context.Context,
+	query string,
+	params ...interface{},
+) (response []*LoanInfo, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	rows, err := dbRunner.Query(ctx, query, params...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]*LoanInfo, 0)
+	for rr.ScanNext() {
+		loan := &LoanInfo{}
+		rr.ReadAllToStruct(loan)
+		response = append(response, loan)
+	}
+
+	err = rr.Error()
+
+	return
+}
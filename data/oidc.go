@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Store a login which is waiting for the identity provider
+	CreateOidcLogin = createOidcLogin
+
+	// Consume a pending login. Returns nil if the login is unknown or
+	// expired.
+	UseOidcLogin = useOidcLogin
+
+	// Returns the user linked to the subject of an identity provider
+	GetOidcUser = getOidcUser
+
+	// Link a user to the subject of an identity provider
+	LinkOidcSubject = linkOidcSubject
+)
+
+// This struct contains all database columns converted to Go types
+type OidcLoginEntity struct {
+	LoginState   string
+	Nonce        string
+	CodeVerifier string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+func createOidcLogin(
+	ctx context.Context,
+	state, nonce, codeVerifier string,
+	expiresAt time.Time,
+) (err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	// Logins which were never completed are cleaned up here
+	query := `
+        DELETE FROM oidc_login
+        WHERE expires_at <= now()`
+
+	_, err = dbRunner.Exec(ctx, query)
+	if err != nil {
+		return
+	}
+
+	query = `
+        INSERT INTO oidc_login (login_state, nonce, code_verifier, expires_at)
+        VALUES ($1, $2, $3, $4)`
+
+	_, err = dbRunner.Exec(ctx, query, state, nonce, codeVerifier, expiresAt)
+	return
+}
+
+func useOidcLogin(
+	ctx context.Context,
+	state string,
+) (response *OidcLoginEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        DELETE FROM oidc_login
+        WHERE login_state = $1
+        AND expires_at > now()
+        RETURNING
+            login_state AS "LoginState",
+            nonce AS "Nonce",
+            code_verifier AS "CodeVerifier",
+            created_at AS "CreatedAt",
+            expires_at AS "ExpiresAt"`
+
+	rows, err := dbRunner.Query(ctx, query, state)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &OidcLoginEntity{}
+		rr.ReadAllToStruct(response)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func getOidcUser(
+	ctx context.Context,
+	issuer, subject string,
+) (response *UserInfo, err error) {
+	query := `
+        SELECT` + userInfoColumns + `
+        FROM library_user
+        WHERE oidc_issuer = $1
+        AND oidc_subject = $2`
+
+	return queryUser(ctx, query, issuer, subject)
+}
+
+// Only users which are not yet linked to a subject are linked
+func linkOidcSubject(
+	ctx context.Context,
+	userId, issuer, subject string,
+) (rowsAffected int64, err error) {
+	query := `
+        UPDATE library_user
+        SET oidc_issuer = $2,
+            oidc_subject = $3
+        WHERE user_id = $1
+        AND oidc_subject IS NULL`
+
+	return executeQueryWithRowsAffected(ctx, query, userId, issuer, subject)
+}
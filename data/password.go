@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Return true if the password of an active user matches
+	CheckPassword = checkPassword
+
+	// Replace the password of a user
+	ChangePassword = changePassword
+
+	// Return the id and username of an active user
+	GetActiveUserByUsername = getActiveUserByUsername
+
+	// Store a password reset token of a user
+	CreatePasswordReset = createPasswordReset
+
+	// Mark a valid password reset token as used and return its user id
+	UsePasswordReset = usePasswordReset
+)
+
+func checkPassword(
+	ctx context.Context,
+	userId, password string,
+) (response bool, err error) {
+	query := `
+        SELECT user_id
+        FROM library_user
+        WHERE user_id = $1
+        AND user_password = crypt($2, user_password)`
+
+	matchingId, err := executeQueryWithStringResponse(
+		ctx,
+		query,
+		userId,
+		password,
+	)
+
+	return matchingId != "", err
+}
+
+func changePassword(
+	ctx context.Context,
+	userId, password string,
+) (response int64, err error) {
+	query := `
+        UPDATE library_user
+        SET
+            user_password = crypt($2, gen_salt('bf')),
+            password_changed_at = now()
+        WHERE user_id = $1`
+
+	return executeQueryWithRowsAffected(ctx, query, userId, password)
+}
+
+func getActiveUserByUsername(
+	ctx context.Context,
+	username string,
+) (response *UserInfo, err error) {
+	query := `
+        SELECT` + userInfoColumns + `
+        FROM library_user
+        WHERE username = $1
+        AND user_status = $2`
+
+	return queryUser(ctx, query, username, values.UserStatusActive)
+}
+
+func createPasswordReset(
+	ctx context.Context,
+	userId, token string,
+	expiresAt time.Time,
+) (err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        INSERT INTO password_reset (user_id, token_hash, expires_at)
+        VALUES ($1, encode(digest($2, 'sha256'), 'hex'), $3)`
+
+	_, err = dbRunner.Exec(ctx, query, userId, token, expiresAt)
+	return
+}
+
+func usePasswordReset(
+	ctx context.Context,
+	token string,
+) (response string, err error) {
+	query := `
+        UPDATE password_reset
+        SET used_at = now()
+        WHERE token_hash = encode(digest($1, 'sha256'), 'hex')
+        AND used_at IS NULL
+        AND expires_at > now()
+        RETURNING user_id`
+
+	return executeQueryWithStringResponse(ctx, query, token)
+}
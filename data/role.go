@@ -0,0 +1,287 @@
+package data
+
+import (
+	"context"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Return all roles with their permissions
+	GetRoles = getRoles
+
+	// Retrieve a role with its permissions
+	GetRole = getRole
+
+	// Return the id of the role with the given name, or zero if there is none
+	GetRoleIdByName = getRoleIdByName
+
+	// Return the permissions granted by a role
+	GetRolePermissions = getRolePermissions
+
+	// Return all permissions which can be granted
+	GetPermissions = getPermissions
+
+	// Create a role and return its id
+	CreateRole = createRole
+
+	// Rename a role
+	RenameRole = renameRole
+
+	// Replace the permissions granted by a role
+	SetRolePermissions = setRolePermissions
+
+	// Delete a role
+	DeleteRole = deleteRole
+
+	// Return the number of users who have a role
+	CountUsersWithRole = countUsersWithRole
+)
+
+// Struct which is used when querying for roles
+type RoleInfo struct {
+	UserRole    int64
+	Name        string
+	Permissions []string
+}
+
+// This struct contains all database columns converted to Go types
+type PermissionEntity struct {
+	Permission  string
+	Description string
+}
+
+func getRoles(ctx context.Context) (response []*RoleInfo, err error) {
+	query := `
+        SELECT
+            r.code,
+            r.user_role,
+            COALESCE(string_agg(p.permission, ',' ORDER BY p.permission), '')
+        FROM enum_user_role r
+        LEFT JOIN role_permission p ON p.user_role = r.code
+        GROUP BY r.code
+        ORDER BY r.code`
+
+	return queryRoles(ctx, query)
+}
+
+func getRole(
+	ctx context.Context,
+	userRole int,
+) (response *RoleInfo, err error) {
+	query := `
+        SELECT
+            r.code,
+            r.user_role,
+            COALESCE(string_agg(p.permission, ',' ORDER BY p.permission), '')
+        FROM enum_user_role r
+        LEFT JOIN role_permission p ON p.user_role = r.code
+        WHERE r.code = $1
+        GROUP BY r.code`
+
+	roles, err := queryRoles(ctx, query, userRole)
+	if err != nil || len(roles) == 0 {
+		return
+	}
+
+	response = roles[0]
+	return
+}
+
+func getRoleIdByName(
+	ctx context.Context,
+	name string,
+) (response int64, err error) {
+	query := `
+        SELECT code
+        FROM enum_user_role
+        WHERE user_role = $1`
+
+	return executeQueryWithInt64Response(ctx, query, name)
+}
+
+func getRolePermissions(
+	ctx context.Context,
+	userRole int,
+) (response []string, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        SELECT permission
+        FROM role_permission
+        WHERE user_role = $1`
+
+	rows, err := dbRunner.Query(ctx, query, userRole)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]string, 0)
+	for rr.ScanNext() {
+		response = append(response, rr.ReadByIdxString(0))
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func getPermissions(
+	ctx context.Context,
+) (response []*PermissionEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        SELECT
+            permission AS "Permission",
+            permission_description AS "Description"
+        FROM permission
+        ORDER BY permission`
+
+	rows, err := dbRunner.Query(ctx, query)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]*PermissionEntity, 0)
+	for rr.ScanNext() {
+		permission := &PermissionEntity{}
+		rr.ReadAllToStruct(permission)
+		response = append(response, permission)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func createRole(
+	ctx context.Context,
+	name string,
+) (response int64, err error) {
+	query := `
+        INSERT INTO enum_user_role (code, user_role)
+        SELECT COALESCE(MAX(code), 0) + 1, $1
+        FROM enum_user_role
+        RETURNING code`
+
+	return executeQueryWithInt64Response(ctx, query, name)
+}
+
+func renameRole(
+	ctx context.Context,
+	userRole int,
+	name string,
+) (response int64, err error) {
+	query := `
+        UPDATE enum_user_role
+        SET user_role = $2
+        WHERE code = $1`
+
+	return executeQueryWithRowsAffected(ctx, query, userRole, name)
+}
+
+// Must be called within a transaction
+func setRolePermissions(
+	ctx context.Context,
+	userRole int,
+	permissions []string,
+) (err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        DELETE FROM role_permission
+        WHERE user_role = $1`
+
+	_, err = dbRunner.Exec(ctx, query, userRole)
+	if err != nil {
+		return
+	}
+
+	query = `
+        INSERT INTO role_permission (user_role, permission)
+        VALUES ($1, $2)
+        ON CONFLICT DO NOTHING`
+
+	for _, permission := range permissions {
+		_, err = dbRunner.Exec(ctx, query, userRole, permission)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func deleteRole(
+	ctx context.Context,
+	userRole int,
+) (response int64, err error) {
+	query := `
+        DELETE FROM enum_user_role
+        WHERE code = $1`
+
+	return executeQueryWithRowsAffected(ctx, query, userRole)
+}
+
+func countUsersWithRole(
+	ctx context.Context,
+	userRole int,
+) (response int64, err error) {
+	query := `
+        SELECT COUNT(*)
+        FROM library_user
+        WHERE user_role = $1`
+
+	return executeQueryWithInt64Response(ctx, query, userRole)
+}
+
+func queryRoles(
+	ctx context.Context,
+	query string,
+	params ...interface{},
+) (response []*RoleInfo, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	rows, err := dbRunner.Query(ctx, query, params...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]*RoleInfo, 0)
+	for rr.ScanNext() {
+		role := &RoleInfo{
+			UserRole:    rr.ReadByIdxInt64(0),
+			Name:        rr.ReadByIdxString(1),
+			Permissions: splitList(rr.ReadByIdxString(2)),
+		}
+
+		response = append(response, role)
+	}
+
+	err = rr.Error()
+
+	return
+}
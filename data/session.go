@@ -0,0 +1,164 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Start a new session for a user
+	CreateSession = createSession
+
+	// Replace the token of an active session and extend its expiry
+	RefreshSession = refreshSession
+
+	// Extend the expiry of an active session without replacing its token
+	ExtendSession = extendSession
+
+	// Revoke an active session by its id
+	RevokeSessionById = revokeSessionById
+
+	// Revoke all active sessions of a user
+	RevokeUserSessions = revokeUserSessions
+
+	// Revoke all active sessions of a user except one
+	RevokeOtherUserSessions = revokeOtherUserSessions
+)
+
+// This struct contains all database columns converted to Go types
+type SessionEntity struct {
+	SessionId string
+	UserId    string
+	Token     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time `json:",omitempty"`
+}
+
+const sessionEntityColumns = `
+            session_id AS "SessionId",
+            user_id AS "UserId",
+            token AS "Token",
+            issued_at AS "IssuedAt",
+            expires_at AS "ExpiresAt",
+            revoked_at AS "RevokedAt"`
+
+func createSession(
+	ctx context.Context,
+	userId string,
+	expiresAt time.Time,
+) (response *SessionEntity, err error) {
+	query := `
+        INSERT INTO session (user_id, expires_at)
+        VALUES ($1, $2)
+        RETURNING` + sessionEntityColumns
+
+	return querySession(ctx, query, userId, expiresAt)
+}
+
+func refreshSession(
+	ctx context.Context,
+	sessionId string,
+	expiresAt time.Time,
+) (response *SessionEntity, err error) {
+	query := `
+        UPDATE session
+        SET
+            token = uuid_generate_v4(),
+            issued_at = now(),
+            expires_at = $2
+        WHERE session_id = $1
+        AND revoked_at IS NULL
+        AND expires_at > now()
+        RETURNING` + sessionEntityColumns
+
+	return querySession(ctx, query, sessionId, expiresAt)
+}
+
+func extendSession(
+	ctx context.Context,
+	sessionId string,
+	expiresAt time.Time,
+) (response *SessionEntity, err error) {
+	query := `
+        UPDATE session
+        SET expires_at = $2
+        WHERE session_id = $1
+        AND revoked_at IS NULL
+        AND expires_at > now()
+        RETURNING` + sessionEntityColumns
+
+	return querySession(ctx, query, sessionId, expiresAt)
+}
+
+func revokeSessionById(
+	ctx context.Context,
+	sessionId string,
+) (response int64, err error) {
+	query := `
+        UPDATE session
+        SET revoked_at = now()
+        WHERE session_id = $1
+        AND revoked_at IS NULL`
+
+	return executeQueryWithRowsAffected(ctx, query, sessionId)
+}
+
+func revokeUserSessions(
+	ctx context.Context,
+	userId string,
+) (response int64, err error) {
+	query := `
+        UPDATE session
+        SET revoked_at = now()
+        WHERE user_id = $1
+        AND revoked_at IS NULL`
+
+	return executeQueryWithRowsAffected(ctx, query, userId)
+}
+
+func revokeOtherUserSessions(
+	ctx context.Context,
+	userId, sessionId string,
+) (response int64, err error) {
+	query := `
+        UPDATE session
+        SET revoked_at = now()
+        WHERE user_id = $1
+        AND session_id <> $2
+        AND revoked_at IS NULL`
+
+	return executeQueryWithRowsAffected(ctx, query, userId, sessionId)
+}
+
+func querySession(
+	ctx context.Context,
+	query string,
+	params ...interface{},
+) (response *SessionEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	rows, err := dbRunner.Query(ctx, query, params...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &SessionEntity{}
+		rr.ReadAllToStruct(response)
+	}
+
+	err = rr.Error()
+
+	return
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Lock the failed logins of a username or IP key until the transaction
+	// ends and return them. A key without failures gets an empty row, so
+	// that parallel logins wait for each other.
+	LockLoginThrottle = lockLoginThrottle
+
+	// Count a failed login, failures older than the window are forgotten.
+	// Returns the number of recent failures.
+	RecordLoginFailure = recordLoginFailure
+
+	// Lock a key until the given time and start counting failures again
+	LockLogin = lockLogin
+
+	// Forget the failed logins and lock of a key
+	ClearLoginThrottle = clearLoginThrottle
+)
+
+// This struct contains all database columns converted to Go types
+type LoginThrottleEntity struct {
+	ThrottleKey    string
+	FailedAttempts int64
+	LastFailedAt   time.Time
+	LockedUntil    *time.Time
+}
+
+func lockLoginThrottle(
+	ctx context.Context,
+	throttleKey string,
+) (response *LoginThrottleEntity, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        INSERT INTO login_throttle (throttle_key)
+        VALUES ($1)
+        ON CONFLICT (throttle_key) DO UPDATE
+        SET throttle_key = EXCLUDED.throttle_key
+        RETURNING
+            throttle_key AS "ThrottleKey",
+            failed_attempts AS "FailedAttempts",
+            last_failed_at AS "LastFailedAt",
+            locked_until AS "LockedUntil"`
+
+	rows, err := dbRunner.Query(ctx, query, throttleKey)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &LoginThrottleEntity{}
+		rr.ReadAllToStruct(response)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func recordLoginFailure(
+	ctx context.Context,
+	throttleKey string,
+	window time.Duration,
+) (response int64, err error) {
+	query := `
+        INSERT INTO login_throttle (throttle_key, failed_attempts)
+        VALUES ($1, 1)
+        ON CONFLICT (throttle_key) DO UPDATE
+        SET
+            failed_attempts = CASE
+                WHEN login_throttle.last_failed_at <
+                    now() - make_interval(secs => $2)
+                THEN 1
+                ELSE login_throttle.failed_attempts + 1
+            END,
+            last_failed_at = now()
+        RETURNING failed_attempts`
+
+	return executeQueryWithInt64Response(
+		ctx,
+		query,
+		throttleKey,
+		window.Seconds(),
+	)
+}
+
+func lockLogin(
+	ctx context.Context,
+	throttleKey string,
+	lockedUntil time.Time,
+) (err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        UPDATE login_throttle
+        SET
+            failed_attempts = 0,
+            locked_until = $2
+        WHERE throttle_key = $1`
+
+	_, err = dbRunner.Exec(ctx, query, throttleKey, lockedUntil)
+	return
+}
+
+func clearLoginThrottle(
+	ctx context.Context,
+	throttleKey string,
+) (response int64, err error) {
+	query := `
+        DELETE FROM login_throttle
+        WHERE throttle_key = $1`
+
+	return executeQueryWithRowsAffected(ctx, query, throttleKey)
+}
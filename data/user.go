@@ -1,30 +1,139 @@
 package data
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/nordluma/go-bookstore/server/dbserver"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
 
 var (
-	// Find user with provided username and password and return user's token
+	// Find user with provided username and password and return user's id
 	LoginUser = loginUser
 
-	// Return user's role if the user has a token, otherwise return an empty
-	// string.
+	// Return user's role if the token belongs to an active session, otherwise
+	// return zero
 	AuthorizeUser = authorizeUser
 
-	// Return userId from provided token
-	GetUserId = getUserId
+	// Return the id and role of the user of an active session
+	GetSessionUser = getSessionUser
+
+	// Create a pending member, returns nil if the username is taken
+	CreatePendingUser = createPendingUser
+
+	// Return users with the given status
+	GetUsersByStatus = getUsersByStatus
+
+	// Approve or reject a pending user
+	ReviewUser = reviewUser
+
+	// Create an active user, returns nil if the username is taken
+	CreateUser = createUser
+
+	// Retrieve a user
+	GetUser = getUser
+
+	// Return a list of users whose username or name matches the search term
+	GetUsers = getUsers
+
+	// Update the name and membership of a user
+	UpdateUser = updateUser
+
+	// Change the role of a user
+	ChangeUserRole = changeUserRole
+
+	// Suspend an active user
+	SuspendUser = suspendUser
+
+	// Reactivate a suspended user
+	UnsuspendUser = unsuspendUser
+
+	// Delete a user
+	DeleteUser = deleteUser
+
+	// Lock the user row, so that changes to the loans and fines of the user
+	// are serialized. Returns an empty string if the user doesn't exist. Must
+	// be called within a transaction.
+	LockUser = lockUser
+
+	// Return the number of loans and fine entries of a user, including
+	// returned loans and settled fines
+	CountCirculationRecords = countCirculationRecords
+
+	// Lock the user row and return the information needed to decide if the
+	// user is allowed to borrow. Must be called within a transaction.
+	LockBorrowingStatus = lockBorrowingStatus
 )
 
+// Struct which describes the user of a session
+type SessionUser struct {
+	SessionId string
+	UserId    string
+	UserRole  int64
+}
+
+// Struct which is used when librarians queries for users
+type UserInfo struct {
+	UserId              string
+	Username            string
+	FullName            string
+	UserRole            int64
+	Status              int64
+	MemberCategory      string     `json:",omitempty"`
+	MembershipExpiresAt *time.Time `json:",omitempty"`
+	CreatedAt           time.Time
+	ReviewedBy          string     `json:",omitempty"`
+	ReviewedAt          *time.Time `json:",omitempty"`
+	SuspensionReason    string     `json:",omitempty"`
+	SuspendedBy         string     `json:",omitempty"`
+	SuspendedAt         *time.Time `json:",omitempty"`
+}
+
+const userInfoColumns = `
+            user_id AS "UserId",
+            username AS "Username",
+            full_name AS "FullName",
+            user_role AS "UserRole",
+            user_status AS "Status",
+            member_category AS "MemberCategory",
+            membership_expires_at AS "MembershipExpiresAt",
+            created_at AS "CreatedAt",
+            reviewed_by AS "ReviewedBy",
+            reviewed_at AS "ReviewedAt",
+            suspension_reason AS "SuspensionReason",
+            suspended_by AS "SuspendedBy",
+            suspended_at AS "SuspendedAt"`
+
+// Struct which is used when checking if a user can borrow
+type BorrowingStatus struct {
+	UserRole            int64
+	RoleName            string
+	MemberCategory      string
+	MembershipExpiresAt *time.Time
+	OpenLoans           int64
+	OverdueLoans        int64
+}
+
 func loginUser(
 	ctx context.Context,
 	username, password string,
 ) (response string, err error) {
 	query := `
-        SELECT token
+        SELECT user_id
         FROM library_user
-        WHERE username = $1 
-        AND user_password = crypt($2, user_password)`
+        WHERE username = $1
+        AND user_password = crypt($2, user_password)
+        AND user_status = $3`
 
-	return executeQueryWithStringResponse(ctx, query, username, password)
+	return executeQueryWithStringResponse(
+		ctx,
+		query,
+		username,
+		password,
+		values.UserStatusActive,
+	)
 }
 
 func authorizeUser(
@@ -32,21 +141,440 @@ func authorizeUser(
 	token string,
 ) (response int64, err error) {
 	query := `
-        SELECT user_role
-        FROM library_user
-        WHERE token = $1`
+        SELECT u.user_role
+        FROM session s
+        JOIN library_user u ON u.user_id = s.user_id
+        WHERE s.token = $1
+        AND s.revoked_at IS NULL
+        AND s.expires_at > now()
+        AND u.user_status = $2`
 
-	return executeQueryWithInt64Response(ctx, query, token)
+	return executeQueryWithInt64Response(
+		ctx,
+		query,
+		token,
+		values.UserStatusActive,
+	)
 }
 
-func getUserId(
+func getSessionUser(
 	ctx context.Context,
 	token string,
-) (response string, err error) {
+) (response *SessionUser, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        SELECT
+            s.session_id AS "SessionId",
+            u.user_id AS "UserId",
+            u.user_role AS "UserRole"
+        FROM session s
+        JOIN library_user u ON u.user_id = s.user_id
+        WHERE s.token = $1
+        AND s.revoked_at IS NULL
+        AND s.expires_at > now()
+        AND u.user_status = $2`
+
+	rows, err := dbRunner.Query(ctx, query, token, values.UserStatusActive)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &SessionUser{}
+		rr.ReadAllToStruct(response)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func createPendingUser(
+	ctx context.Context,
+	username, password, fullName string,
+) (response *UserInfo, err error) {
+	query := `
+        INSERT INTO library_user (
+            username, user_password, full_name, user_role, user_status
+        )
+        VALUES ($1, crypt($2, gen_salt('bf')), $3, $4, $5)
+        ON CONFLICT (username) DO NOTHING
+        RETURNING` + userInfoColumns
+
+	return queryUser(
+		ctx,
+		query,
+		username,
+		password,
+		fullName,
+		values.UserRoleMember,
+		values.UserStatusPending,
+	)
+}
+
+func getUsersByStatus(
+	ctx context.Context,
+	status int,
+	rowOffset, rowLimit int,
+) (response []*UserInfo, err error) {
+	query := `
+        SELECT` + userInfoColumns + `
+        FROM library_user
+        WHERE user_status = $1
+        ORDER BY created_at
+        OFFSET $2
+        LIMIT $3`
+
+	return queryUsers(ctx, query, status, rowOffset, rowLimit)
+}
+
+func createUser(
+	ctx context.Context,
+	username, password, fullName string,
+	userRole int,
+	memberCategory util.NullString,
+	membershipExpiresAt *time.Time,
+) (response *UserInfo, err error) {
+	query := `
+        INSERT INTO library_user (
+            username,
+            user_password,
+            full_name,
+            user_role,
+            user_status,
+            member_category,
+            membership_expires_at
+        )
+        VALUES ($1, crypt($2, gen_salt('bf')), $3, $4, $5, $6, $7)
+        ON CONFLICT (username) DO NOTHING
+        RETURNING` + userInfoColumns
+
+	return queryUser(
+		ctx,
+		query,
+		username,
+		password,
+		fullName,
+		userRole,
+		values.UserStatusActive,
+		memberCategory,
+		membershipExpiresAt,
+	)
+}
+
+func getUser(
+	ctx context.Context,
+	userId string,
+) (response *UserInfo, err error) {
+	query := `
+        SELECT` + userInfoColumns + `
+        FROM library_user
+        WHERE user_id = $1`
+
+	return queryUser(ctx, query, userId)
+}
+
+func getUsers(
+	ctx context.Context,
+	searchTerm string,
+	rowOffset, rowLimit int,
+) (response []*UserInfo, err error) {
+	query := `
+        SELECT` + userInfoColumns + `
+        FROM library_user
+        WHERE username ILIKE '%%' || $1 || '%%'
+        OR full_name ILIKE '%%' || $1 || '%%'
+        ORDER BY full_name, username
+        OFFSET $2
+        LIMIT $3`
+
+	return queryUsers(ctx, query, searchTerm, rowOffset, rowLimit)
+}
+
+func updateUser(
+	ctx context.Context,
+	userId, fullName string,
+	memberCategory util.NullString,
+	membershipExpiresAt *time.Time,
+) (response *UserInfo, err error) {
+	query := `
+        UPDATE library_user
+        SET
+            full_name = $2,
+            member_category = $3,
+            membership_expires_at = $4
+        WHERE user_id = $1
+        RETURNING` + userInfoColumns
+
+	return queryUser(
+		ctx,
+		query,
+		userId,
+		fullName,
+		memberCategory,
+		membershipExpiresAt,
+	)
+}
+
+func changeUserRole(
+	ctx context.Context,
+	userId string,
+	userRole int,
+) (response *UserInfo, err error) {
+	query := `
+        UPDATE library_user
+        SET user_role = $2
+        WHERE user_id = $1
+        RETURNING` + userInfoColumns
+
+	return queryUser(ctx, query, userId, userRole)
+}
+
+func suspendUser(
+	ctx context.Context,
+	userId, reason, suspendedBy string,
+) (response *UserInfo, err error) {
+	query := `
+        UPDATE library_user
+        SET
+            user_status = $2,
+            suspension_reason = $3,
+            suspended_by = $4,
+            suspended_at = now()
+        WHERE user_id = $1
+        AND user_status = $5
+        RETURNING` + userInfoColumns
+
+	return queryUser(
+		ctx,
+		query,
+		userId,
+		values.UserStatusSuspended,
+		reason,
+		suspendedBy,
+		values.UserStatusActive,
+	)
+}
+
+func unsuspendUser(
+	ctx context.Context,
+	userId string,
+) (response *UserInfo, err error) {
+	query := `
+        UPDATE library_user
+        SET
+            user_status = $2,
+            suspension_reason = NULL,
+            suspended_by = NULL,
+            suspended_at = NULL
+        WHERE user_id = $1
+        AND user_status = $3
+        RETURNING` + userInfoColumns
+
+	return queryUser(
+		ctx,
+		query,
+		userId,
+		values.UserStatusActive,
+		values.UserStatusSuspended,
+	)
+}
+
+func deleteUser(
+	ctx context.Context,
+	userId string,
+) (response int64, err error) {
+	query := `
+        DELETE FROM library_user
+        WHERE user_id = $1`
+
+	return executeQueryWithRowsAffected(ctx, query, userId)
+}
+
+func reviewUser(
+	ctx context.Context,
+	userId string,
+	status int,
+	reviewedBy string,
+) (response *UserInfo, err error) {
+	query := `
+        UPDATE library_user
+        SET
+            user_status = $2,
+            reviewed_by = $3,
+            reviewed_at = now()
+        WHERE user_id = $1
+        AND user_status = $4
+        RETURNING` + userInfoColumns
+
+	return queryUser(
+		ctx,
+		query,
+		userId,
+		status,
+		reviewedBy,
+		values.UserStatusPending,
+	)
+}
+
+func lockUser(ctx context.Context, userId string) (string, error) {
 	query := `
         SELECT user_id
         FROM library_user
-        WHERE token = $1`
+        WHERE user_id = $1
+        FOR UPDATE`
+
+	return executeQueryWithStringResponse(ctx, query, userId)
+}
+
+func countCirculationRecords(
+	ctx context.Context,
+	userId string,
+) (response int64, err error) {
+	query := `
+        SELECT
+            (SELECT COUNT(*) FROM loan WHERE member_id = $1) +
+            (SELECT COUNT(*) FROM fine_entry WHERE member_id = $1)`
+
+	return executeQueryWithInt64Response(ctx, query, userId)
+}
+
+func lockBorrowingStatus(
+	ctx context.Context,
+	userId string,
+) (response *BorrowingStatus, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	query := `
+        SELECT
+            u.user_role AS "UserRole",
+            r.user_role AS "RoleName",
+            u.member_category AS "MemberCategory",
+            u.membership_expires_at AS "MembershipExpiresAt"
+        FROM library_user u
+        JOIN enum_user_role r ON r.code = u.user_role
+        WHERE u.user_id = $1
+        FOR UPDATE OF u`
+
+	rows, err := dbRunner.Query(ctx, query, userId)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &BorrowingStatus{}
+		rr.ReadAllToStruct(response)
+	}
+
+	err = rr.Error()
+	if err != nil || response == nil {
+		return
+	}
+
+	// The loans are counted in a statement of their own. Under READ
+	// COMMITTED a statement sees the data from before it waited for the
+	// lock, so counting in the locking statement would miss a loan which
+	// was created by the transaction holding the lock.
+	query = `
+        SELECT
+            COUNT(*) AS "OpenLoans",
+            COUNT(*) FILTER (WHERE due_at < now()) AS "OverdueLoans"
+        FROM loan
+        WHERE member_id = $1
+        AND returned_at IS NULL`
+
+	countRows, err := dbRunner.Query(ctx, query, userId)
+	if err != nil {
+		return
+	}
+
+	defer countRows.Close()
+
+	rr, err = dbserver.GetRowReader(countRows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		rr.ReadAllToStruct(response)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func queryUser(
+	ctx context.Context,
+	query string,
+	params ...interface{},
+) (response *UserInfo, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	rows, err := dbRunner.Query(ctx, query, params...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	if rr.ScanNext() {
+		response = &UserInfo{}
+		rr.ReadAllToStruct(response)
+	}
+
+	err = rr.Error()
+
+	return
+}
+
+func queryUsers(
+	ctx context.Context,
+	query string,
+	params ...interface{},
+) (response []*UserInfo, err error) {
+	dbRunner := ctx.Value(values.ContextKeyDbRunner).(dbserver.Runner)
+
+	rows, err := dbRunner.Query(ctx, query, params...)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	rr, err := dbserver.GetRowReader(rows)
+	if err != nil {
+		return
+	}
+
+	response = make([]*UserInfo, 0)
+	for rr.ScanNext() {
+		user := &UserInfo{}
+		rr.ReadAllToStruct(user)
+		response = append(response, user)
+	}
+
+	err = rr.Error()
 
-	return executeQueryWithStringResponse(ctx, query, token)
+	return
 }
@@ -2,166 +2,259 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/nordluma/go-bookstore/config"
 	"github.com/nordluma/go-bookstore/core"
 	"github.com/nordluma/go-bookstore/server/dbserver"
 	"github.com/nordluma/go-bookstore/util"
 	"github.com/nordluma/go-bookstore/values"
 )
 
-// handle add http requests
-var Handle = handle
+var (
+	// handle add http requests
+	Handle = handle
+
+	// Authenticate a request before it is handled and store the result in
+	// the context
+	AuthenticateEarly = authenticateEarly
+
+	// Return whether the path matches the pattern in the same way as the
+	// routes of the API, where a segment such as {id} matches any segment
+	MatchPath = matchPath
+)
 
 type Request struct {
 	Authorization string
 	Body          io.Reader
 	URL           *url.URL
 	Method        string
+	ClientIP      string
+
+	// Headers which are sent with the response
+	ResponseHeader http.Header
 }
 
 func handle(ctx context.Context, request *Request) (interface{}, error) {
-	if !strings.HasPrefix(request.URL.Path, "/api") {
+	// Paths such as /apimember must not reach the routes of /member
+	path := request.URL.Path
+	if path != "/api" && !strings.HasPrefix(path, "/api/") {
 		return nil, util.ErrInvalidAPICall
 	}
 
-	uri := request.URL.Path[4:]
-	ctx = dbserver.PrepareDbRunner(ctx)
+	if request.ResponseHeader == nil {
+		request.ResponseHeader = make(http.Header)
+	}
 
-	switch {
-	case strings.HasPrefix(uri, "/open"):
-		return handleOpen(ctx, uri[5:], request)
-	case strings.HasPrefix(uri, "/member"):
-		userRole, err := core.AuthorizeUser(ctx, request.Authorization)
-		if err != nil {
-			return nil, util.ErrNotAuthenticated
-		}
+	route, params, allowed := routes.match(
+		request.Method,
+		strings.TrimPrefix(path, "/api"),
+	)
+	if allowed == nil {
+		return nil, util.ErrInvalidAPICall
+	}
 
-		if userRole != values.UserRoleMember {
-			return nil, util.ErrNotAuthenticated
+	if route == nil {
+		request.ResponseHeader.Set("Allow", strings.Join(allowed, ", "))
+		if request.Method == http.MethodOptions {
+			return nil, nil
 		}
 
-		return handleMember(ctx, uri[7:], request)
-	case strings.HasPrefix(uri, "/librarian"):
-		userRole, err := core.AuthorizeUser(ctx, request.Authorization)
+		return nil, util.ErrMethodNotAllowed
+	}
+
+	ctx = dbserver.PrepareDbRunner(ctx)
+
+	if route.access.authenticate {
+		var err error
+		ctx, err = authenticate(ctx, request)
 		if err != nil {
-			return nil, util.ErrNotAuthenticated
+			return nil, err
 		}
+	}
 
-		if userRole != values.UserRoleLibrarian {
-			return nil, util.ErrNotAuthenticated
+	if route.access.permission != "" {
+		err := requirePermission(ctx, route.access.permission)
+		if err != nil {
+			return nil, err
 		}
-
-		return handleLibrarian(ctx, uri[10:], request)
-	default:
-		return nil, util.ErrInvalidAPICall
 	}
+
+	return route.handler(ctx, request, params)
+}
+
+// Outcome of authenticating a request before it was routed
+type authentication struct {
+	principal *core.Principal
+	err       error
 }
 
-func handleOpen(
+type contextKeyAuthentication struct{}
+
+// Authenticate a request before it is routed, so that middleware can tell
+// users apart. A failure is only reported once the request reaches a route
+// which requires authentication.
+func authenticateEarly(
 	ctx context.Context,
-	uri string,
-	request *Request,
-) (response interface{}, err error) {
-	if uri == "/login" && request.Method == http.MethodPost {
-		return core.Login(ctx, request.Body)
+	authorization, clientIP string,
+) context.Context {
+	if strings.TrimSpace(authorization) == "" {
+		return ctx
+	}
+
+	result := resolvePrincipal(
+		dbserver.PrepareDbRunner(ctx),
+		authorization,
+		clientIP,
+	)
+	if result.err == nil {
+		ctx = core.WithPrincipal(ctx, result.principal)
 	}
 
-	return nil, util.ErrInvalidAPICall
+	return context.WithValue(ctx, contextKeyAuthentication{}, result)
 }
 
-func handleMember(
+// Resolve the user of the request from its Authorization header and store
+// them in the context. Requests which were already authenticated by the
+// middleware are not authenticated again, so that a failed password is only
+// counted once.
+func authenticate(
 	ctx context.Context,
-	uri string,
 	request *Request,
-) (response interface{}, err error) {
-	if !strings.HasPrefix(uri, "/book") {
-		return nil, util.ErrInvalidAPICall
+) (context.Context, error) {
+	result, ok := ctx.Value(contextKeyAuthentication{}).(*authentication)
+	if !ok {
+		result = resolvePrincipal(ctx, request.Authorization, request.ClientIP)
 	}
 
-	uri = uri[5:]
-	switch request.Method {
-	case http.MethodGet:
-		if uri == "" {
-			return nil, util.ErrInvalidAPICall
-		}
+	if result.err == nil {
+		return core.WithPrincipal(ctx, result.principal), nil
+	}
 
-		if strings.HasPrefix(uri, "/all") {
-			searchTerm, rowOffset, rowLimit, err := getParams(request.URL)
-			if err != nil {
-				return nil, util.ErrInvalidAPICall
-			}
-
-			return core.GetAllBooks(
-				ctx,
-				searchTerm,
-				rowOffset,
-				rowLimit,
-				values.UserRoleMember,
-			)
-		}
-	case http.MethodPatch:
-		return nil, core.BorrowOrReturnBook(
-			ctx,
-			request.Authorization,
-			request.Body,
-		)
-	default:
-		return nil, util.ErrInvalidAPICall
+	// Other failures, such as an unreachable database, are not a reason to
+	// ask the client for new credentials
+	_, _, _, errorType := util.IsError(result.err)
+	if errorType == util.ErrNotAuthenticated {
+		setAuthenticateChallenge(request)
 	}
 
-	return
+	return ctx, result.err
 }
 
-func handleLibrarian(
+func resolvePrincipal(
 	ctx context.Context,
-	uri string,
-	request *Request,
-) (response interface{}, err error) {
-	if !strings.HasPrefix(uri, "/book") {
-		return nil, util.ErrInvalidAPICall
+	authorization, clientIP string,
+) *authentication {
+	credentials, err := parseAuthorization(authorization)
+	if err != nil {
+		cause := "Malformed Authorization header"
+		err = util.NewError(
+			cause,
+			util.ErrorCodeInvalidCredentials,
+			util.ErrNotAuthenticated,
+			err,
+		)
+		return &authentication{err: err}
 	}
 
-	uri = uri[5:]
+	principal, err := core.AuthenticateUser(ctx, credentials, clientIP)
+	return &authentication{principal: principal, err: err}
+}
 
-	switch request.Method {
-	case http.MethodPost:
-		return core.CreateBook(ctx, request.Body)
-	case http.MethodGet:
-		if uri == "" {
-			return nil, util.ErrInvalidAPICall
-		}
+// Split the Authorization header into the credentials of its scheme. A
+// header without a scheme is taken as a bearer token, which is how tokens
+// were sent before schemes were supported.
+func parseAuthorization(header string) (*core.Credentials, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return nil, nil
+	}
 
-		if strings.HasPrefix(uri, "/all") {
-			searchTerm, rowOffset, rowLimit, err := getParams(request.URL)
-			if err != nil {
-				return nil, util.ErrInvalidAPICall
-			}
-
-			return core.GetAllBooks(
-				ctx,
-				searchTerm,
-				rowOffset,
-				rowLimit,
-				values.UserRoleLibrarian,
-			)
+	scheme, value, found := strings.Cut(header, " ")
+	if !found {
+		return &core.Credentials{
+			Scheme: values.AuthSchemeBearer,
+			Token:  header,
+		}, nil
+	}
+
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.EqualFold(scheme, values.AuthSchemeBearer):
+		return &core.Credentials{
+			Scheme: values.AuthSchemeBearer,
+			Token:  value,
+		}, nil
+	case strings.EqualFold(scheme, values.AuthSchemeApiKey):
+		return &core.Credentials{
+			Scheme: values.AuthSchemeApiKey,
+			Token:  value,
+		}, nil
+	case strings.EqualFold(scheme, values.AuthSchemeBasic):
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
 		}
 
-		return core.GetBook(ctx, uri[1:])
-	case http.MethodPut:
-		return core.UpdateBook(ctx, request.Body)
-	case http.MethodDelete:
-		if uri == "" {
-			return nil, util.ErrInvalidAPICall
+		username, password, found := strings.Cut(string(decoded), ":")
+		if !found {
+			return nil, errors.New("malformed basic credentials")
 		}
-		return nil, core.DeleteBook(ctx, uri[1:])
+
+		return &core.Credentials{
+			Scheme:   values.AuthSchemeBasic,
+			Username: username,
+			Password: password,
+		}, nil
 	default:
-		return nil, util.ErrInvalidAPICall
+		return nil, errors.New("unsupported scheme " + scheme)
+	}
+}
+
+// Tell the client which schemes are accepted. The bearer challenge names an
+// error only when credentials were sent, as RFC 6750 asks.
+func setAuthenticateChallenge(request *Request) {
+	realm := config.GetAuthRealm()
+
+	bearer := fmt.Sprintf("%s realm=%q", values.AuthSchemeBearer, realm)
+	if request.Authorization != "" {
+		bearer += `, error="invalid_token"`
 	}
+
+	request.ResponseHeader.Add("WWW-Authenticate", bearer)
+
+	if config.GetAuthBasicEnabled() {
+		request.ResponseHeader.Add(
+			"WWW-Authenticate",
+			fmt.Sprintf(
+				`%s realm=%q, charset="UTF-8"`,
+				values.AuthSchemeBasic,
+				realm,
+			),
+		)
+	}
+}
+
+// Refuse the request unless the role of the user grants the permission
+func requirePermission(ctx context.Context, permission string) error {
+	if !core.GetPrincipal(ctx).HasPermission(permission) {
+		cause := "Missing permission " + permission
+		return util.NewError(
+			cause,
+			util.ErrorCodePermissionDenied,
+			util.ErrForbidden,
+			nil,
+		)
+	}
+
+	return nil
 }
 
 func getParams(
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/nordluma/go-bookstore/util"
+)
+
+func TestHandleRequiresAPIPrefix(t *testing.T) {
+	tests := map[string]bool{
+		"/api/member/book/all":  true,
+		"/apimember/book/all":   false,
+		"/apix/member/book/all": false,
+		"/member/book/all":      false,
+	}
+
+	for path, found := range tests {
+		t.Run(path, func(t *testing.T) {
+			// OPTIONS is answered by the router without a database
+			request := &Request{
+				URL:    &url.URL{Path: path},
+				Method: http.MethodOptions,
+			}
+
+			_, err := Handle(context.Background(), request)
+			if found {
+				if err != nil {
+					t.Errorf("Expected the route to be found, got %v", err)
+				}
+				return
+			}
+
+			status := util.MapErrorTypeToHTTPStatus(err)
+			if err != util.ErrInvalidAPICall || status != http.StatusNotFound {
+				t.Errorf("Expected 404, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Handler of a route. Path parameters are passed by the names used in the
+// pattern of the route.
+type routeHandler func(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error)
+
+// Who may call a route. Routes which require a permission also require
+// authentication.
+type routeAccess struct {
+	authenticate bool
+	permission   string
+}
+
+var (
+	// Anyone may call the route
+	public = routeAccess{}
+
+	// Any authenticated user may call the route
+	authenticated = routeAccess{authenticate: true}
+)
+
+// Only authenticated users with the permission may call the route
+func requires(permission string) routeAccess {
+	return routeAccess{authenticate: true, permission: permission}
+}
+
+type route struct {
+	method   string
+	segments []string
+	access   routeAccess
+	handler  routeHandler
+}
+
+// Routes which are matched by method and path. Patterns are split into
+// segments, where a segment in braces such as {id} matches any non-empty
+// segment.
+type router struct {
+	routes []*route
+}
+
+// Add a route to the router
+func (r *router) handle(
+	method, pattern string,
+	access routeAccess,
+	handler routeHandler,
+) {
+	r.routes = append(r.routes, &route{
+		method:   method,
+		segments: splitPath(pattern),
+		access:   access,
+		handler:  handler,
+	})
+}
+
+// Find the route of the request. When the path matches but the method
+// doesn't, the route is nil and the methods which the path allows are
+// returned instead. Both are empty when nothing matches the path.
+func (r *router) match(
+	method, path string,
+) (match *route, params map[string]string, allowed []string) {
+	segments := splitPath(path)
+
+	// The most specific pattern wins, so /book/all is matched before
+	// /book/{id}
+	var best []string
+	for _, route := range r.routes {
+		if !matchSegments(route.segments, segments) {
+			continue
+		}
+
+		if best == nil || isMoreSpecific(route.segments, best) {
+			best = route.segments
+		}
+	}
+
+	if best == nil {
+		return nil, nil, nil
+	}
+
+	for _, route := range r.routes {
+		if !equalSegments(route.segments, best) {
+			continue
+		}
+
+		if route.method == method {
+			match = route
+		}
+
+		allowed = append(allowed, route.method)
+	}
+
+	allowed = append(allowed, http.MethodOptions)
+	sort.Strings(allowed)
+
+	if match == nil {
+		return nil, nil, allowed
+	}
+
+	params = make(map[string]string)
+	for i, segment := range match.segments {
+		if name, ok := paramName(segment); ok {
+			params[name] = segments[i]
+		}
+	}
+
+	return match, params, allowed
+}
+
+func matchPath(pattern, path string) bool {
+	return matchSegments(splitPath(pattern), splitPath(path))
+}
+
+// Split a path into its segments. Empty segments are kept, so that paths
+// such as /users//role don't match any route.
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) != len(segments) {
+		return false
+	}
+
+	for i, segment := range pattern {
+		if _, ok := paramName(segment); ok {
+			if segments[i] == "" {
+				return false
+			}
+
+			continue
+		}
+
+		if segment != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// A pattern is more specific than another if it has a fixed segment where
+// the other one has a parameter
+func isMoreSpecific(pattern, other []string) bool {
+	for i := range pattern {
+		_, isParam := paramName(pattern[i])
+		_, isOtherParam := paramName(other[i])
+		if isParam != isOtherParam {
+			return isOtherParam
+		}
+	}
+
+	return false
+}
+
+func equalSegments(pattern, other []string) bool {
+	if len(pattern) != len(other) {
+		return false
+	}
+
+	for i := range pattern {
+		if pattern[i] != other[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func paramName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+
+	return "", false
+}
@@ -0,0 +1,815 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nordluma/go-bookstore/core"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+// All routes of the API. Paths are relative to /api.
+var routes = newRouter()
+
+func newRouter() *router {
+	bookRead := requires(values.PermissionBookRead)
+	bookWrite := requires(values.PermissionBookWrite)
+	loanSelf := requires(values.PermissionLoanSelf)
+	loanCheckout := requires(values.PermissionLoanCheckout)
+	fineManage := requires(values.PermissionFineManage)
+	userManage := requires(values.PermissionUserManage)
+	roleManage := requires(values.PermissionRoleManage)
+	apiKeyManage := requires(values.PermissionApiKeyManage)
+
+	r := &router{}
+
+	// Login and account recovery
+	r.handle(http.MethodPost, "/open/login", public, login)
+	r.handle(http.MethodPost, "/open/register", public, register)
+	r.handle(
+		http.MethodPost,
+		"/open/password-reset",
+		public,
+		requestPasswordReset,
+	)
+	r.handle(
+		http.MethodPost,
+		"/open/password-reset/confirm",
+		public,
+		resetPassword,
+	)
+	r.handle(http.MethodGet, "/open/oidc/login", public, startOidcLogin)
+	r.handle(http.MethodGet, "/open/oidc/callback", public, finishOidcLogin)
+
+	// Session of the user
+	r.handle(http.MethodPost, "/session/refresh", authenticated, refresh)
+	r.handle(http.MethodPost, "/session/logout", authenticated, logout)
+	r.handle(http.MethodPost, "/session/logout-all", authenticated, logoutAll)
+	r.handle(
+		http.MethodPost,
+		"/session/password",
+		authenticated,
+		changePassword,
+	)
+
+	// Members
+	r.handle(http.MethodGet, "/member/book/all", bookRead, getMemberBooks)
+	r.handle(
+		http.MethodGet,
+		"/member/book/{bookId}/copies",
+		bookRead,
+		getMemberBookCopies,
+	)
+	r.handle(http.MethodGet, "/member/holds", loanSelf, getMemberHolds)
+	r.handle(http.MethodPost, "/member/holds", loanSelf, placeHold)
+	r.handle(http.MethodDelete, "/member/holds/{holdId}", loanSelf, cancelHold)
+	r.handle(http.MethodGet, "/member/loans", loanSelf, getMemberLoans)
+	r.handle(
+		http.MethodGet,
+		"/member/loans/history",
+		loanSelf,
+		getMemberLoanHistory,
+	)
+	r.handle(http.MethodPost, "/member/loans", loanSelf, borrowBook)
+	r.handle(
+		http.MethodPost,
+		"/member/loans/{loanId}/return",
+		loanSelf,
+		returnBook,
+	)
+	r.handle(
+		http.MethodPost,
+		"/member/loans/{loanId}/renew",
+		loanSelf,
+		renewMemberLoan,
+	)
+	r.handle(http.MethodGet, "/member/fines", loanSelf, getMemberFines)
+
+	// Catalogue
+	r.handle(http.MethodPost, "/librarian/book", bookWrite, createBook)
+	r.handle(http.MethodPut, "/librarian/book", bookWrite, updateBook)
+	r.handle(http.MethodGet, "/librarian/book/all", bookRead, getBooks)
+	r.handle(http.MethodGet, "/librarian/book/{bookId}", bookRead, getBook)
+	r.handle(
+		http.MethodDelete,
+		"/librarian/book/{bookId}",
+		bookWrite,
+		deleteBook,
+	)
+	r.handle(
+		http.MethodGet,
+		"/librarian/book/{bookId}/copies",
+		bookRead,
+		getBookCopies,
+	)
+	r.handle(
+		http.MethodGet,
+		"/librarian/book/{bookId}/loans",
+		loanCheckout,
+		getBookLoans,
+	)
+	r.handle(http.MethodPost, "/librarian/copies", bookWrite, createCopy)
+	r.handle(http.MethodPut, "/librarian/copies", bookWrite, updateCopy)
+	r.handle(http.MethodGet, "/librarian/copies/{copyId}", bookRead, getCopy)
+	r.handle(
+		http.MethodDelete,
+		"/librarian/copies/{copyId}",
+		bookWrite,
+		deleteCopy,
+	)
+
+	// Circulation
+	r.handle(http.MethodPost, "/librarian/checkout", loanCheckout, checkOut)
+	r.handle(http.MethodPost, "/librarian/checkin", loanCheckout, checkIn)
+	r.handle(
+		http.MethodGet,
+		"/librarian/loans/overdue",
+		loanCheckout,
+		getOverdueLoans,
+	)
+	r.handle(
+		http.MethodPost,
+		"/librarian/loans/{loanId}/renew",
+		loanCheckout,
+		renewLoan,
+	)
+	r.handle(
+		http.MethodGet,
+		"/librarian/fines/{memberId}",
+		fineManage,
+		getFines,
+	)
+	r.handle(
+		http.MethodPost,
+		"/librarian/fines/{memberId}/payments",
+		fineManage,
+		recordFinePayment,
+	)
+	r.handle(
+		http.MethodPost,
+		"/librarian/fines/{memberId}/waivers",
+		fineManage,
+		waiveFine,
+	)
+
+	// Users
+	r.handle(
+		http.MethodGet,
+		"/librarian/registrations",
+		userManage,
+		getRegistrations,
+	)
+	r.handle(
+		http.MethodPost,
+		"/librarian/registrations/{userId}/approve",
+		userManage,
+		approveRegistration,
+	)
+	r.handle(
+		http.MethodPost,
+		"/librarian/registrations/{userId}/reject",
+		userManage,
+		rejectRegistration,
+	)
+	r.handle(http.MethodGet, "/librarian/users", userManage, getUsers)
+	r.handle(http.MethodPost, "/librarian/users", userManage, createUser)
+	r.handle(http.MethodPut, "/librarian/users", userManage, updateUser)
+	r.handle(http.MethodGet, "/librarian/users/{userId}", userManage, getUser)
+	r.handle(
+		http.MethodDelete,
+		"/librarian/users/{userId}",
+		userManage,
+		deleteUser,
+	)
+	r.handle(
+		http.MethodPost,
+		"/librarian/users/{userId}/role",
+		userManage,
+		changeUserRole,
+	)
+	r.handle(
+		http.MethodPost,
+		"/librarian/users/{userId}/suspend",
+		userManage,
+		suspendUser,
+	)
+	r.handle(
+		http.MethodPost,
+		"/librarian/users/{userId}/unsuspend",
+		userManage,
+		unsuspendUser,
+	)
+	r.handle(
+		http.MethodPost,
+		"/librarian/users/{userId}/unlock",
+		userManage,
+		unlockUser,
+	)
+	r.handle(
+		http.MethodGet,
+		"/librarian/users/{userId}/loans",
+		loanCheckout,
+		getLoans,
+	)
+	r.handle(
+		http.MethodGet,
+		"/librarian/users/{userId}/loans/history",
+		loanCheckout,
+		getLoanHistory,
+	)
+
+	// API keys
+	r.handle(http.MethodGet, "/librarian/api-keys", apiKeyManage, getApiKeys)
+	r.handle(
+		http.MethodPost,
+		"/librarian/api-keys",
+		apiKeyManage,
+		createApiKey,
+	)
+	r.handle(
+		http.MethodDelete,
+		"/librarian/api-keys/{keyId}",
+		apiKeyManage,
+		revokeApiKey,
+	)
+
+	// Roles
+	r.handle(
+		http.MethodGet,
+		"/admin/permissions",
+		roleManage,
+		getPermissions,
+	)
+	r.handle(http.MethodGet, "/admin/roles", roleManage, getRoles)
+	r.handle(http.MethodPost, "/admin/roles", roleManage, createRole)
+	r.handle(http.MethodPut, "/admin/roles/{roleId}", roleManage, updateRole)
+	r.handle(
+		http.MethodDelete,
+		"/admin/roles/{roleId}",
+		roleManage,
+		deleteRole,
+	)
+
+	return r
+}
+
+func login(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.Login(ctx, request.Body, request.ClientIP)
+}
+
+func register(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.Register(ctx, request.Body)
+}
+
+func requestPasswordReset(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.RequestPasswordReset(ctx, request.Body)
+}
+
+func resetPassword(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.ResetPassword(ctx, request.Body)
+}
+
+func startOidcLogin(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.StartOidcLogin(ctx)
+}
+
+func finishOidcLogin(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	query := request.URL.Query()
+	return core.FinishOidcLogin(
+		ctx,
+		query.Get("code"),
+		query.Get("state"),
+		query.Get("error"),
+	)
+}
+
+func refresh(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.RefreshSession(ctx)
+}
+
+func logout(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.Logout(ctx)
+}
+
+func logoutAll(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.LogoutAll(ctx)
+}
+
+func changePassword(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.ChangePassword(ctx, request.Body)
+}
+
+func getMemberBooks(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	searchTerm, rowOffset, rowLimit, err := getParams(request.URL)
+	if err != nil {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	return core.GetAllBooks(
+		ctx,
+		searchTerm,
+		rowOffset,
+		rowLimit,
+		values.UserRoleMember,
+	)
+}
+
+func getMemberBookCopies(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetBookCopies(ctx, params["bookId"], values.UserRoleMember)
+}
+
+func getMemberHolds(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetMemberHolds(ctx)
+}
+
+func placeHold(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.PlaceHold(ctx, request.Body)
+}
+
+func cancelHold(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.CancelHold(ctx, params["holdId"])
+}
+
+func getMemberLoans(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetMemberLoans(ctx)
+}
+
+func getMemberLoanHistory(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	_, rowOffset, rowLimit, err := getParams(request.URL)
+	if err != nil {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	return core.GetMemberLoanHistory(ctx, rowOffset, rowLimit)
+}
+
+func borrowBook(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.BorrowBook(ctx, request.Body)
+}
+
+func returnBook(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.ReturnBook(ctx, params["loanId"])
+}
+
+func renewMemberLoan(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.RenewLoan(ctx, params["loanId"], values.UserRoleMember)
+}
+
+func getMemberFines(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	_, rowOffset, rowLimit, err := getParams(request.URL)
+	if err != nil {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	return core.GetMemberFines(ctx, rowOffset, rowLimit)
+}
+
+func createBook(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.CreateBook(ctx, request.Body)
+}
+
+func updateBook(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.UpdateBook(ctx, request.Body)
+}
+
+func getBooks(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	searchTerm, rowOffset, rowLimit, err := getParams(request.URL)
+	if err != nil {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	return core.GetAllBooks(
+		ctx,
+		searchTerm,
+		rowOffset,
+		rowLimit,
+		values.UserRoleLibrarian,
+	)
+}
+
+func getBook(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetBook(ctx, params["bookId"])
+}
+
+func deleteBook(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.DeleteBook(ctx, params["bookId"])
+}
+
+func getBookCopies(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetBookCopies(
+		ctx,
+		params["bookId"],
+		values.UserRoleLibrarian,
+	)
+}
+
+func getBookLoans(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	_, rowOffset, rowLimit, err := getParams(request.URL)
+	if err != nil {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	return core.GetBookLoans(ctx, params["bookId"], rowOffset, rowLimit)
+}
+
+func createCopy(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.CreateCopy(ctx, request.Body)
+}
+
+func updateCopy(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.UpdateCopy(ctx, request.Body)
+}
+
+func getCopy(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetCopy(ctx, params["copyId"])
+}
+
+func deleteCopy(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.DeleteCopy(ctx, params["copyId"])
+}
+
+func checkOut(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.CheckOutCopy(ctx, request.Body)
+}
+
+func checkIn(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.CheckInCopy(ctx, request.Body)
+}
+
+func getOverdueLoans(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	_, rowOffset, rowLimit, err := getParams(request.URL)
+	if err != nil {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	return core.GetOverdueLoans(ctx, rowOffset, rowLimit)
+}
+
+func renewLoan(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.RenewLoan(ctx, params["loanId"], values.UserRoleLibrarian)
+}
+
+func getFines(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	_, rowOffset, rowLimit, err := getParams(request.URL)
+	if err != nil {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	return core.GetFines(ctx, params["memberId"], rowOffset, rowLimit)
+}
+
+func recordFinePayment(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.RecordFinePayment(ctx, params["memberId"], request.Body)
+}
+
+func waiveFine(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.WaiveFine(ctx, params["memberId"], request.Body)
+}
+
+func getRegistrations(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	_, rowOffset, rowLimit, err := getParams(request.URL)
+	if err != nil {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	return core.GetRegistrations(ctx, rowOffset, rowLimit)
+}
+
+func approveRegistration(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.ApproveRegistration(ctx, params["userId"])
+}
+
+func rejectRegistration(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.RejectRegistration(ctx, params["userId"])
+}
+
+func getUsers(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	searchTerm, rowOffset, rowLimit, err := getParams(request.URL)
+	if err != nil {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	return core.GetUsers(ctx, searchTerm, rowOffset, rowLimit)
+}
+
+func createUser(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.CreateUser(ctx, request.Body)
+}
+
+func updateUser(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.UpdateUser(ctx, request.Body)
+}
+
+func getUser(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetUser(ctx, params["userId"])
+}
+
+func deleteUser(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.DeleteUser(ctx, params["userId"])
+}
+
+func changeUserRole(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.ChangeUserRole(ctx, params["userId"], request.Body)
+}
+
+func suspendUser(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.SuspendUser(ctx, params["userId"], request.Body)
+}
+
+func unsuspendUser(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.UnsuspendUser(ctx, params["userId"])
+}
+
+func unlockUser(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.UnlockUser(ctx, params["userId"])
+}
+
+func getLoans(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetLoans(ctx, params["userId"])
+}
+
+func getLoanHistory(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	_, rowOffset, rowLimit, err := getParams(request.URL)
+	if err != nil {
+		return nil, util.ErrInvalidAPICall
+	}
+
+	return core.GetLoanHistory(ctx, params["userId"], rowOffset, rowLimit)
+}
+
+func getApiKeys(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetApiKeys(ctx)
+}
+
+func createApiKey(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.CreateApiKey(ctx, request.Body)
+}
+
+func revokeApiKey(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.RevokeApiKey(ctx, params["keyId"])
+}
+
+func getPermissions(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetPermissions(ctx)
+}
+
+func getRoles(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.GetRoles(ctx)
+}
+
+func createRole(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.CreateRole(ctx, request.Body)
+}
+
+func updateRole(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return core.UpdateRole(ctx, params["roleId"], request.Body)
+}
+
+func deleteRole(
+	ctx context.Context,
+	request *Request,
+	params map[string]string,
+) (interface{}, error) {
+	return nil, core.DeleteRole(ctx, params["roleId"])
+}
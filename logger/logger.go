@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+var (
+	// Initialize the default logger with the format and level in the
+	// configuration
+	InitializeLogger = initializeLogger
+)
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+func initializeLogger() error {
+	level, err := parseLevel(config.GetLogLevel())
+	if err != nil {
+		return err
+	}
+
+	handler, err := newHandler(os.Stderr, config.GetLogFormat(), level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(handler))
+
+	return nil
+}
+
+func newHandler(
+	writer io.Writer,
+	format string,
+	level slog.Level,
+) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case LogFormatJSON:
+		return &contextHandler{slog.NewJSONHandler(writer, options)}, nil
+	case LogFormatText:
+		return &contextHandler{slog.NewTextHandler(writer, options)}, nil
+	default:
+		return nil, fmt.Errorf("Unknown log format %q", format)
+	}
+}
+
+func parseLevel(name string) (level slog.Level, err error) {
+	err = level.UnmarshalText([]byte(strings.TrimSpace(name)))
+	if err != nil {
+		err = fmt.Errorf("Unknown log level %q", name)
+	}
+
+	return
+}
+
+// Adds the id of the request in the context to every log line, so that all
+// lines of a request can be found by its id
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(
+	ctx context.Context,
+	record slog.Record,
+) error {
+	if ctx != nil {
+		requestId, _ := ctx.Value(values.ContextKeyRequestId).(string)
+		if requestId != "" {
+			record.AddAttrs(slog.String("request_id", requestId))
+		}
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
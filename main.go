@@ -1,10 +1,14 @@
 package main
 
 import (
-	"log"
+	"log/slog"
+	"os"
 	"sync"
 
 	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/core"
+	"github.com/nordluma/go-bookstore/logger"
+	"github.com/nordluma/go-bookstore/notifier"
 	"github.com/nordluma/go-bookstore/server"
 	"github.com/nordluma/go-bookstore/server/dbserver"
 
@@ -12,18 +16,34 @@ import (
 )
 
 func main() {
-	log.Println("Starting library server")
+	slog.Info("Starting library server")
 
-	log.Println("Initializing configs")
+	slog.Info("Initializing configs")
 	err := config.InitConfig("bookstore", nil)
 	if err != nil {
-		log.Fatalf("Failed to read config: %v\n", err)
+		fatal("Failed to read config", err)
 	}
 
-	log.Println("Initializing database")
+	err = logger.InitializeLogger()
+	if err != nil {
+		fatal("Could not initialize logger", err)
+	}
+
+	err = core.ValidateAuthConfig()
+	if err != nil {
+		fatal("Invalid auth configuration", err)
+	}
+
+	slog.Info("Initializing database")
 	err = dbserver.InitializeDb()
 	if err != nil {
-		log.Fatalf("Could not access database: %v\n", err)
+		fatal("Could not access database", err)
+	}
+
+	slog.Info("Initializing notifier")
+	err = notifier.InitializeNotifier()
+	if err != nil {
+		fatal("Could not initialize notifier", err)
 	}
 
 	var wg sync.WaitGroup
@@ -31,15 +51,21 @@ func main() {
 
 	go func() {
 		defer wg.Done()
-		log.Println("Starting HTTP server")
+		slog.Info("Starting HTTP server")
 		err := server.StartHTTPServer()
 		if err != nil {
-			log.Fatalf("Could not start HTTP server: %v\n", err)
+			fatal("Could not start HTTP server", err)
 		}
 
-		log.Println("HTTP server gracefully shut down")
+		slog.Info("HTTP server gracefully shut down")
 	}()
 	wg.Wait()
 
-	log.Println("Server stopped")
+	slog.Info("Server stopped")
+}
+
+// Log the error and exit
+func fatal(message string, err error) {
+	slog.Error(message, "error", err)
+	os.Exit(1)
 }
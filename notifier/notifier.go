@@ -0,0 +1,125 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+)
+
+var (
+	// Initialize the notifier selected in the configuration
+	InitializeNotifier = initializeNotifier
+
+	// Replace the notifier with another delivery channel
+	SetNotifier = setNotifier
+
+	// Deliver a message to a user
+	Send = send
+
+	activeNotifier Notifier = &logNotifier{}
+)
+
+const (
+	NotifierTypeLog  = "log"
+	NotifierTypeFile = "file"
+)
+
+// Message which is delivered to a user
+type Message struct {
+	Recipient string
+	Subject   string
+	Body      string
+}
+
+// Notifier delivers messages to users. Implementations must be safe for
+// concurrent use.
+type Notifier interface {
+	Notify(ctx context.Context, message *Message) error
+}
+
+func initializeNotifier() error {
+	switch notifierType := config.GetNotifierType(); notifierType {
+	case NotifierTypeLog:
+		slog.Warn(
+			"The log notifier writes messages, including password reset " +
+				"tokens, to the server log. Only use it for development.",
+		)
+		setNotifier(&logNotifier{})
+	case NotifierTypeFile:
+		filePath := config.GetNotifierFilePath()
+		if filePath == "" {
+			return errors.New("File path of the file notifier is empty")
+		}
+
+		setNotifier(&fileNotifier{filePath: filePath})
+	default:
+		return fmt.Errorf("Unknown notifier type %q", notifierType)
+	}
+
+	return nil
+}
+
+func setNotifier(notifier Notifier) {
+	activeNotifier = notifier
+}
+
+func send(ctx context.Context, message *Message) error {
+	return activeNotifier.Notify(ctx, message)
+}
+
+// Writes messages to the server log, where anyone who can read the log can
+// use the tokens in them. Only meant for development.
+type logNotifier struct{}
+
+func (n *logNotifier) Notify(ctx context.Context, message *Message) error {
+	slog.InfoContext(
+		ctx,
+		"notification",
+		"to", message.Recipient,
+		"subject", message.Subject,
+		"body", message.Body,
+	)
+
+	return nil
+}
+
+// Appends messages to a file
+type fileNotifier struct {
+	filePath string
+	mutex    sync.Mutex
+}
+
+func (n *fileNotifier) Notify(ctx context.Context, message *Message) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	file, err := os.OpenFile(
+		n.filePath,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0o600,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(
+		file,
+		"%v\nTo: %v\nSubject: %v\n\n%v\n\n",
+		time.Now().Format(time.RFC3339),
+		message.Recipient,
+		message.Subject,
+		message.Body,
+	)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
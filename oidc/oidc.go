@@ -0,0 +1,429 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Discover the configuration of an identity provider
+	NewProvider = newProvider
+
+	// Returns the PKCE code challenge of a code verifier
+	CodeChallenge = codeChallenge
+)
+
+const (
+	// Accepted difference between our clock and the clock of the provider
+	clockSkew = time.Minute
+
+	// Minimum time between refetching the keys of the provider
+	keyRefreshInterval = time.Minute
+
+	// Maximum size of a response read from the provider
+	maxResponseSize = 1 << 20
+)
+
+// Configuration of this application as a client of an identity provider
+type Config struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// Client used for requests to the provider
+	HTTPClient *http.Client
+}
+
+// Identity provider which was discovered from its issuer URL. Safe for
+// concurrent use.
+type Provider struct {
+	config   Config
+	metadata *providerMetadata
+
+	mu            sync.Mutex
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// Verified claims of an ID token
+type Claims struct {
+	Issuer    string
+	Subject   string
+	ExpiresAt time.Time
+	Nonce     string
+
+	claims map[string]interface{}
+}
+
+// Returns a claim as a string. Booleans and numbers are formatted and any
+// other claim is returned as an empty string.
+func (c *Claims) GetString(name string) string {
+	switch value := c.claims[name].(type) {
+	case string:
+		return value
+	case bool, float64:
+		return fmt.Sprint(value)
+	default:
+		return ""
+	}
+}
+
+func newProvider(ctx context.Context, config Config) (*Provider, error) {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	discoveryURL := config.Issuer + "/.well-known/openid-configuration"
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		discoveryURL,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &providerMetadata{}
+	err = doJSON(config.HTTPClient, request, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+
+	if metadata.Issuer != config.Issuer {
+		return nil, fmt.Errorf("issuer mismatch %q", metadata.Issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" ||
+		metadata.TokenEndpoint == "" ||
+		metadata.JwksURI == "" {
+		return nil, errors.New("provider metadata is incomplete")
+	}
+
+	return &Provider{config: config, metadata: metadata}, nil
+}
+
+// Returns the URL which the user is sent to for logging in
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientId},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.metadata.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange an authorization code for an ID token and verify it
+func (p *Provider) Exchange(
+	ctx context.Context,
+	code, codeVerifier, nonce string,
+) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		p.metadata.TokenEndpoint,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(
+		url.QueryEscape(p.config.ClientId),
+		url.QueryEscape(p.config.ClientSecret),
+	)
+
+	type tokenResponse struct {
+		IdToken string `json:"id_token"`
+	}
+
+	response := &tokenResponse{}
+	err = doJSON(p.config.HTTPClient, request, response)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange failed: %w", err)
+	}
+
+	if response.IdToken == "" {
+		return nil, errors.New("token response has no ID token")
+	}
+
+	return p.verifyIdToken(ctx, response.IdToken, nonce)
+}
+
+// Verify the signature and the claims of an ID token which is signed with
+// RS256
+func (p *Provider) verifyIdToken(
+	ctx context.Context,
+	token, nonce string,
+) (*Claims, error) {
+	type tokenHeader struct {
+		Algorithm string `json:"alg"`
+		KeyId     string `json:"kid"`
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	encoding := base64.RawURLEncoding
+
+	headerJSON, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	header := &tokenHeader{}
+	err = json.Unmarshal(headerJSON, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Algorithm != "RS256" {
+		return nil, errors.New("unsupported algorithm " + header.Algorithm)
+	}
+
+	key, err := p.getKey(ctx, header.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, errors.New("signature mismatch")
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{claims: map[string]interface{}{}}
+	err = json.Unmarshal(payload, &claims.claims)
+	if err != nil {
+		return nil, err
+	}
+
+	claims.Issuer = claims.GetString("iss")
+	claims.Subject = claims.GetString("sub")
+	claims.Nonce = claims.GetString("nonce")
+
+	expiresAt, ok := claims.claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("ID token has no expiry")
+	}
+
+	claims.ExpiresAt = time.Unix(int64(expiresAt), 0)
+
+	err = p.validateClaims(claims, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) validateClaims(claims *Claims, nonce string) error {
+	if claims.Issuer != p.metadata.Issuer {
+		return fmt.Errorf("issuer mismatch %q", claims.Issuer)
+	}
+
+	if claims.Subject == "" {
+		return errors.New("ID token has no subject")
+	}
+
+	if !hasAudience(claims.claims["aud"], p.config.ClientId) {
+		return errors.New("ID token is not issued for this client")
+	}
+
+	if time.Now().Add(-clockSkew).After(claims.ExpiresAt) {
+		return errors.New("ID token is expired")
+	}
+
+	if claims.Nonce != nonce {
+		return errors.New("nonce mismatch")
+	}
+
+	return nil
+}
+
+// Returns the public key with the key id. Keys are refetched when the
+// provider has rotated its keys.
+func (p *Provider) getKey(
+	ctx context.Context,
+	keyId string,
+) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[keyId]; ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetchedAt) < keyRefreshInterval {
+		return nil, errors.New("unknown key id " + keyId)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.keys[keyId]; ok {
+		return key, nil
+	}
+
+	return nil, errors.New("unknown key id " + keyId)
+}
+
+func (p *Provider) fetchKeys(
+	ctx context.Context,
+) (map[string]*rsa.PublicKey, error) {
+	type jsonWebKey struct {
+		KeyType  string `json:"kty"`
+		KeyId    string `json:"kid"`
+		Use      string `json:"use"`
+		Modulus  string `json:"n"`
+		Exponent string `json:"e"`
+	}
+
+	type jsonWebKeySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		p.metadata.JwksURI,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := &jsonWebKeySet{}
+	err = doJSON(p.config.HTTPClient, request, keySet)
+	if err != nil {
+		return nil, fmt.Errorf("fetching keys failed: %w", err)
+	}
+
+	encoding := base64.RawURLEncoding
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range keySet.Keys {
+		if key.KeyType != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		modulus, err := encoding.DecodeString(key.Modulus)
+		if err != nil {
+			continue
+		}
+
+		exponent, err := encoding.DecodeString(key.Exponent)
+		if err != nil || len(exponent) > 4 {
+			continue
+		}
+
+		keys[key.KeyId] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(modulus),
+			E: int(new(big.Int).SetBytes(exponent).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+func codeChallenge(codeVerifier string) string {
+	digest := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// The audience claim is either a single client id or a list of them
+func hasAudience(audience interface{}, clientId string) bool {
+	switch audience := audience.(type) {
+	case string:
+		return audience == clientId
+	case []interface{}:
+		for _, value := range audience {
+			if value == clientId {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func doJSON(client *http.Client, request *http.Request, v interface{}) error {
+	request.Header.Set("Accept", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	return json.Unmarshal(body, v)
+}
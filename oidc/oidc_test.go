@@ -0,0 +1,176 @@
+package oidc_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nordluma/go-bookstore/oidc"
+	"github.com/nordluma/go-bookstore/oidc/oidctest"
+)
+
+const (
+	testClientId     = "bookstore"
+	testClientSecret = "secret"
+	testRedirectURL  = "http://localhost:8080/api/open/oidc/callback"
+	testNonce        = "nonce"
+	testCodeVerifier = "code-verifier"
+)
+
+func newTestProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+
+	idp, err := oidctest.NewServer(testClientId, testClientSecret)
+	if err != nil {
+		t.Fatalf("Failed to start identity provider: %v", err)
+	}
+
+	t.Cleanup(idp.Close)
+
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientId:     testClientId,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to discover identity provider: %v", err)
+	}
+
+	return idp, provider
+}
+
+// Authorize a login and exchange its code
+func exchange(
+	t *testing.T,
+	idp *oidctest.Server,
+	provider *oidc.Provider,
+	claims map[string]interface{},
+) (*oidc.Claims, error) {
+	t.Helper()
+
+	authorizationURL := provider.AuthCodeURL(
+		"state",
+		testNonce,
+		testCodeVerifier,
+	)
+	code, err := idp.Authorize(authorizationURL, claims)
+	if err != nil {
+		t.Fatalf("Failed to authorize login: %v", err)
+	}
+
+	return provider.Exchange(
+		context.Background(),
+		code,
+		testCodeVerifier,
+		testNonce,
+	)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	_, provider := newTestProvider(t)
+
+	authorizationURL, err := url.Parse(
+		provider.AuthCodeURL("state", testNonce, testCodeVerifier),
+	)
+	if err != nil {
+		t.Fatalf("Failed to parse authorization URL: %v", err)
+	}
+
+	query := authorizationURL.Query()
+	expected := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientId,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email",
+		"state":                 "state",
+		"nonce":                 testNonce,
+		"code_challenge":        oidc.CodeChallenge(testCodeVerifier),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range expected {
+		if query.Get(name) != value {
+			t.Errorf("Expected %v %q, got %q", name, value, query.Get(name))
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp, provider := newTestProvider(t)
+
+	claims, err := exchange(t, idp, provider, map[string]interface{}{
+		"sub":            "subject",
+		"email":          "member@example.com",
+		"email_verified": true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+
+	if claims.Issuer != idp.Issuer() || claims.Subject != "subject" {
+		t.Errorf("Unexpected identity %q %q", claims.Issuer, claims.Subject)
+	}
+
+	if claims.GetString("email") != "member@example.com" ||
+		claims.GetString("email_verified") != "true" {
+		t.Errorf("Unexpected email claims %v %v",
+			claims.GetString("email"),
+			claims.GetString("email_verified"),
+		)
+	}
+}
+
+func TestExchangeRejectsInvalidTokens(t *testing.T) {
+	tests := map[string]map[string]interface{}{
+		"nonce mismatch": {"sub": "subject", "nonce": "other"},
+		"wrong audience": {"sub": "subject", "aud": "other-client"},
+		"wrong issuer":   {"sub": "subject", "iss": "https://other.example"},
+		"missing subject": {
+			"sub": "",
+		},
+		"expired": {
+			"sub": "subject",
+			"exp": time.Now().Add(-time.Hour).Unix(),
+		},
+	}
+
+	for name, claims := range tests {
+		t.Run(name, func(t *testing.T) {
+			idp, provider := newTestProvider(t)
+
+			_, err := exchange(t, idp, provider, claims)
+			if err == nil {
+				t.Fatal("Expected the ID token to be rejected")
+			}
+		})
+	}
+}
+
+func TestExchangeRejectsWrongCodeVerifier(t *testing.T) {
+	idp, provider := newTestProvider(t)
+
+	authorizationURL := provider.AuthCodeURL(
+		"state",
+		testNonce,
+		testCodeVerifier,
+	)
+	code, err := idp.Authorize(
+		authorizationURL,
+		map[string]interface{}{"sub": "subject"},
+	)
+	if err != nil {
+		t.Fatalf("Failed to authorize login: %v", err)
+	}
+
+	_, err = provider.Exchange(
+		context.Background(),
+		code,
+		"other-verifier",
+		testNonce,
+	)
+	if err == nil {
+		t.Fatal("Expected the code exchange to fail")
+	}
+}
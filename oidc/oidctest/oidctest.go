@@ -0,0 +1,247 @@
+// Package oidctest provides an identity provider which runs in the test
+// process, so that logins through OpenID Connect can be tested offline.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const keyId = "test-key"
+
+// Identity provider which serves discovery, its keys and a token endpoint.
+// Logins are authorized by calling Authorize instead of through a browser.
+type Server struct {
+	*httptest.Server
+
+	ClientId     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+// Login which was authorized and waits for its code to be exchanged
+type authorization struct {
+	redirectURL   string
+	codeChallenge string
+	claims        map[string]interface{}
+}
+
+// Start a provider with a newly generated key. The server must be closed
+// when the test ends.
+func NewServer(clientId, clientSecret string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		key:          key,
+		codes:        make(map[string]*authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.serveDiscovery)
+	mux.HandleFunc("/keys", s.serveKeys)
+	mux.HandleFunc("/token", s.serveToken)
+	s.Server = httptest.NewServer(mux)
+
+	return s, nil
+}
+
+// Returns the issuer URL of the provider
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// Authorize the login of the authorization URL as if the user had logged in
+// and return the code which the provider would send to the redirect URL.
+// The claims are added to the ID token and replace the claims which the
+// provider sets itself, such as the nonce.
+func (s *Server) Authorize(
+	authorizationURL string,
+	claims map[string]interface{},
+) (string, error) {
+	parsedURL, err := url.Parse(authorizationURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := parsedURL.Query()
+	if query.Get("client_id") != s.ClientId {
+		return "", errors.New("unknown client id")
+	}
+
+	if query.Get("code_challenge_method") != "S256" {
+		return "", errors.New("code challenge method must be S256")
+	}
+
+	tokenClaims := map[string]interface{}{
+		"iss":   s.Issuer(),
+		"aud":   s.ClientId,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		tokenClaims[name] = value
+	}
+
+	code, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.codes[code] = &authorization{
+		redirectURL:   query.Get("redirect_uri"),
+		codeChallenge: query.Get("code_challenge"),
+		claims:        tokenClaims,
+	}
+
+	return code, nil
+}
+
+// Sign the claims as an ID token with the key of the provider
+func (s *Server) SignIdToken(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": keyId,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	signingInput := encoding.EncodeToString(header) + "." +
+		encoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(
+		rand.Reader,
+		s.key,
+		crypto.SHA256,
+		digest[:],
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+func (s *Server) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.Issuer(),
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/keys",
+	})
+}
+
+func (s *Server) serveKeys(w http.ResponseWriter, r *http.Request) {
+	encoding := base64.RawURLEncoding
+	exponent := big.NewInt(int64(s.key.PublicKey.E)).Bytes()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyId,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encoding.EncodeToString(s.key.PublicKey.N.Bytes()),
+			"e":   encoding.EncodeToString(exponent),
+		}},
+	})
+}
+
+// Exchange a code for an ID token. A code can only be used once and only
+// with the code verifier of its challenge.
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	clientId, clientSecret, _ := r.BasicAuth()
+	clientId, _ = url.QueryUnescape(clientId)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if clientId != s.ClientId || clientSecret != s.ClientSecret {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	s.mu.Lock()
+	code := r.PostFormValue("code")
+	login := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	if login == nil ||
+		login.redirectURL != r.PostFormValue("redirect_uri") ||
+		login.codeChallenge != challenge(r.PostFormValue("code_verifier")) {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	idToken, err := s.SignIdToken(login.claims)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func challenge(codeVerifier string) string {
+	digest := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
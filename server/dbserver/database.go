@@ -121,7 +121,7 @@ func readDatabaseTimeZone(
 
 	defer rowsTimeZone.Close()
 	if !rowsTimeZone.Next() {
-		fmt.Errorf("No time zone")
+		err = errors.New("No time zone")
 		return
 	}
 
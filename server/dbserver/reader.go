@@ -3,6 +3,7 @@ package dbserver
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"reflect"
 	"strconv"
 	"time"
@@ -15,6 +16,8 @@ var (
 
 	// Returns RowReader interface which can be used to read data from sql.Rows
 	GetRowReader = getRowReader
+
+	timeType = reflect.TypeOf(time.Time{})
 )
 
 type rowReader struct {
@@ -46,7 +49,9 @@ func getRowReader(rows *sql.Rows) (RowReader, error) {
 	return rr, nil
 }
 
-// RowReader is used to simplify reading of sql.Rows
+// RowReader is used to simplify reading of sql.Rows. A value which can't be
+// read is returned as the zero value of its type, the error is returned by
+// Error and ScanNext doesn't move to the next row anymore.
 type RowReader interface {
 	ScanNext() bool
 	Error() error
@@ -54,12 +59,18 @@ type RowReader interface {
 }
 
 func (rr *rowReader) ScanNext() (hasMore bool) {
+	if rr.lastError != nil {
+		return false
+	}
+
 	if hasMore = rr.rows.Next(); hasMore {
 		err := rr.rows.Scan(rr.valuePtrs...)
 		rr.lastError = err
 		if err != nil {
 			hasMore = false
 		}
+	} else {
+		rr.lastError = rr.rows.Err()
 	}
 
 	return
@@ -84,10 +95,12 @@ func (rr *rowReader) ReadByIdxString(columnIdx int) string {
 	case []byte:
 		return string(value)
 	case nil:
-		panic(ErrorNullValue)
+		rr.setError(columnIdx, ErrorNullValue)
 	default:
-		panic(ErrorWrongType)
+		rr.setError(columnIdx, ErrorWrongType)
 	}
+
+	return ""
 }
 
 func (rr *rowReader) ReadByIdxInt64(columnIdx int) int64 {
@@ -97,16 +110,18 @@ func (rr *rowReader) ReadByIdxInt64(columnIdx int) int64 {
 	case []byte:
 		s := string(value)
 		i, err := strconv.ParseInt(s, 10, 64)
-		if err != nil {
-			panic(ErrorWrongType)
+		if err == nil {
+			return i
 		}
 
-		return i
+		rr.setError(columnIdx, ErrorWrongType)
 	case nil:
-		panic(ErrorNullValue)
+		rr.setError(columnIdx, ErrorNullValue)
 	default:
-		panic(ErrorWrongType)
+		rr.setError(columnIdx, ErrorWrongType)
 	}
+
+	return 0
 }
 
 func (rr *rowReader) ReadByIdxTime(columnIdx int) time.Time {
@@ -115,16 +130,18 @@ func (rr *rowReader) ReadByIdxTime(columnIdx int) time.Time {
 		return value
 	case []byte:
 		time, err := time.Parse(time.RFC3339Nano, string(value))
-		if err != nil {
-			panic(ErrorWrongType)
+		if err == nil {
+			return time
 		}
 
-		return time
+		rr.setError(columnIdx, ErrorWrongType)
 	case nil:
-		panic(ErrorNullValue)
+		rr.setError(columnIdx, ErrorNullValue)
 	default:
-		panic(ErrorWrongType)
+		rr.setError(columnIdx, ErrorWrongType)
 	}
+
+	return time.Time{}
 }
 
 func (rr *rowReader) ReadAllToStruct(p interface{}) {
@@ -158,8 +175,32 @@ func (rr *rowReader) ReadAllToStruct(p interface{}) {
 			reflect.Int32,
 			reflect.Int64:
 			column.SetInt(rr.ReadByIdxInt64(columnIdx))
+		case reflect.Struct:
+			if column.Type() != timeType {
+				rr.setError(columnIdx, ErrorUnsupported)
+				continue
+			}
+
+			column.Set(reflect.ValueOf(rr.ReadByIdxTime(columnIdx)))
+		case reflect.Ptr:
+			if column.Type().Elem() != timeType {
+				rr.setError(columnIdx, ErrorUnsupported)
+				continue
+			}
+
+			t := rr.ReadByIdxTime(columnIdx)
+			column.Set(reflect.ValueOf(&t))
 		default:
-			panic(ErrorUnsupported)
+			rr.setError(columnIdx, ErrorUnsupported)
 		}
 	}
 }
+
+// Keep the first error of the row, which names the column it occurred in
+func (rr *rowReader) setError(columnIdx int, err error) {
+	if rr.lastError != nil {
+		return
+	}
+
+	rr.lastError = fmt.Errorf("Column %v: %w", rr.columns[columnIdx], err)
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"log/slog"
+	"strings"
+	"time"
 )
 
 type dbRunner struct {
@@ -141,6 +144,8 @@ func (run *dbRunner) Query(
 	query string,
 	args ...interface{},
 ) (rows *sql.Rows, err error) {
+	defer logQuery(ctx, query, time.Now(), &err)
+
 	if run.tx != nil {
 		rows, err = run.tx.QueryContext(ctx, query, args...)
 	} else if run.conn != nil {
@@ -173,6 +178,8 @@ func (run *dbRunner) Exec(
 	query string,
 	args ...interface{},
 ) (res sql.Result, err error) {
+	defer logQuery(ctx, query, time.Now(), &err)
+
 	if run.tx != nil {
 		res, err = run.tx.ExecContext(ctx, query, args...)
 	} else if run.conn != nil {
@@ -202,3 +209,26 @@ func (run *dbRunner) Prepare(
 func (run *dbRunner) IsInTransaction() bool {
 	return run.txCount > 0
 }
+
+// Log a query with its duration at the debug level. The arguments are not
+// logged, as they can contain personal data and password hashes.
+func logQuery(
+	ctx context.Context,
+	query string,
+	startTime time.Time,
+	err *error,
+) {
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("query", strings.Join(strings.Fields(query), " ")),
+		slog.Duration("duration", time.Since(startTime)),
+	}
+	if *err != nil {
+		attrs = append(attrs, slog.String("error", (*err).Error()))
+	}
+
+	slog.LogAttrs(ctx, slog.LevelDebug, "query", attrs...)
+}
@@ -2,7 +2,7 @@ package server
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,8 +15,16 @@ import (
 var StartHTTPServer = startHTTPServer
 
 func startHTTPServer() error {
+	handlerAPI, err := buildMiddlewareChain(
+		config.GetHTTPMiddleware(),
+		newHandlerAPI(),
+	)
+	if err != nil {
+		return err
+	}
+
 	mux := http.NewServeMux()
-	mux.Handle("/api/", newHandlerAPI())
+	mux.Handle("/api/", handlerAPI)
 
 	server := http.Server{
 		ReadTimeout:  config.GetHTTPReadTimeout(),
@@ -32,14 +40,14 @@ func startHTTPServer() error {
 		<-interrupt
 		// Gracefully shut down the server
 		if err := server.Shutdown(context.Background()); err != nil {
-			log.Printf("Error shutting down. %v\n", err)
+			slog.Error("Error shutting down", "error", err)
 		}
 	}()
 
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if err == http.ErrServerClosed {
 		err = nil
 	}
 
-	return nil
+	return err
 }
@@ -0,0 +1,549 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nordluma/go-bookstore/config"
+	"github.com/nordluma/go-bookstore/core"
+	handler "github.com/nordluma/go-bookstore/handler"
+	"github.com/nordluma/go-bookstore/util"
+	"github.com/nordluma/go-bookstore/values"
+)
+
+// Middleware wraps a handler with behavior which is shared by all requests
+type middleware func(http.Handler) http.Handler
+
+// Constructors of the middleware which can be named in the configuration
+var middlewareByName = map[string]func() middleware{
+	"request_id": newRequestIdMiddleware,
+	"access_log": newAccessLogMiddleware,
+	"recover":    newRecoverMiddleware,
+	"cors":       newCORSMiddleware,
+	"timeout":    newTimeoutMiddleware,
+	"auth":       newAuthMiddleware,
+	"rate_limit": newRateLimitMiddleware,
+	"gzip":       newGzipMiddleware,
+}
+
+// Header which carries the id of a request
+const requestIdHeader = "X-Request-ID"
+
+// Wrap the handler with the configured middleware. The first middleware
+// sees the request first.
+func buildMiddlewareChain(
+	names []string,
+	next http.Handler,
+) (http.Handler, error) {
+	for i := len(names) - 1; i >= 0; i-- {
+		newMiddleware, ok := middlewareByName[names[i]]
+		if !ok {
+			return nil, fmt.Errorf("Unknown middleware %q", names[i])
+		}
+
+		next = newMiddleware()(next)
+	}
+
+	return next, nil
+}
+
+// Give every request an id which is returned in the response. An id sent by
+// a proxy in front of the server is kept.
+func newRequestIdMiddleware() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestId := r.Header.Get(requestIdHeader)
+			if !isValidRequestId(requestId) {
+				requestId = newRequestId()
+			}
+
+			w.Header().Set(requestIdHeader, requestId)
+			ctx := context.WithValue(
+				r.Context(),
+				values.ContextKeyRequestId,
+				requestId,
+			)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Log the status and duration of every request
+func newAccessLogMiddleware() middleware {
+	redactor := newRedactor()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			startTime := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(recorder, r)
+
+			slog.InfoContext(
+				r.Context(),
+				"request",
+				"status", recorder.getStatus(),
+				"method", r.Method,
+				"uri", redactor.redactURI(r.RequestURI),
+				"duration", time.Since(startTime),
+			)
+		})
+	}
+}
+
+// Turn a panic into an internal error instead of dropping the connection.
+// The panic is logged with the id of the request, so that the error which
+// the client received can be found in the log.
+func newRecoverMiddleware() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder := &statusRecorder{ResponseWriter: w}
+
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+
+				// The server aborts the response on its own
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				slog.ErrorContext(
+					r.Context(),
+					"panic",
+					"panic", fmt.Sprint(p),
+					"stack", string(debug.Stack()),
+				)
+
+				// Part of the response has already been sent
+				if recorder.status != 0 {
+					panic(http.ErrAbortHandler)
+				}
+
+				writeErrorResponse(
+					w,
+					r,
+					http.StatusInternalServerError,
+					util.ErrorResponse{
+						ErrorCode: util.ErrorCodeInternal,
+						Cause:     "Internal error",
+						Reason:    util.ReasonInternal,
+					},
+				)
+			}()
+
+			next.ServeHTTP(recorder, r)
+		})
+	}
+}
+
+// Allow browsers to call the API from the configured origins. Preflight
+// requests are answered without reaching the API.
+func newCORSMiddleware() middleware {
+	allowedOrigins := make(map[string]bool)
+	for _, origin := range config.GetHTTPCORSAllowedOrigins() {
+		allowedOrigins[origin] = true
+	}
+
+	allowedHeaders := strings.Join(config.GetHTTPCORSAllowedHeaders(), ", ")
+	maxAge := strconv.Itoa(int(config.GetHTTPCORSMaxAge().Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			if !allowedOrigins[origin] && !allowedOrigins["*"] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set(
+				"Access-Control-Expose-Headers",
+				requestIdHeader+", WWW-Authenticate",
+			)
+
+			preflightMethod := r.Header.Get("Access-Control-Request-Method")
+			if r.Method != http.MethodOptions || preflightMethod == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", preflightMethod)
+			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// Answer requests which take too long as unavailable. The context of the
+// request is cancelled, so that its database queries stop, and whatever the
+// handler writes afterwards is discarded. Responses are buffered until the
+// handler finishes.
+func newTimeoutMiddleware() middleware {
+	timeout := config.GetHTTPRequestTimeout()
+
+	body, _ := json.Marshal(util.ErrorResponse{
+		ErrorCode: util.ErrorCodeUnavailable,
+		Cause:     "Request timed out",
+		Reason:    util.ReasonUnavailable,
+	})
+
+	return func(next http.Handler) http.Handler {
+		timeoutHandler := http.TimeoutHandler(next, timeout, string(body))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeoutHandler.ServeHTTP(&timeoutResponseWriter{w}, r)
+		})
+	}
+}
+
+// Marks the body which the timeout handler writes as JSON. Responses of the
+// API set their content type themselves.
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (t *timeoutResponseWriter) WriteHeader(status int) {
+	header := t.Header()
+	if status == http.StatusServiceUnavailable &&
+		header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	t.ResponseWriter.WriteHeader(status)
+}
+
+// Authenticate requests before they reach the API, so that the following
+// middleware can tell users apart
+func newAuthMiddleware() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := handler.AuthenticateEarly(
+				r.Context(),
+				r.Header.Get("Authorization"),
+				getClientIP(r),
+			)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Limit the rate of requests per client IP. When the auth middleware runs
+// first, authenticated requests are limited per user or API key instead,
+// but then wrong credentials are checked before the limit applies.
+func newRateLimitMiddleware() middleware {
+	limiter := newRateLimiter(
+		float64(config.GetHTTPRateLimitPerMinute())/60,
+		float64(config.GetHTTPRateLimitBurst()),
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := getRateLimitKey(r)
+
+			retryAfter := limiter.allow(key, time.Now())
+			if retryAfter > 0 {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				writeErrorResponse(
+					w,
+					r,
+					http.StatusTooManyRequests,
+					util.ErrorResponse{
+						Cause:  "Rate limit exceeded",
+						Reason: util.ReasonTooManyRequests,
+					},
+				)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Compress responses for clients which accept gzip
+func newGzipMiddleware() middleware {
+	minSize := config.GetHTTPGzipMinSize()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gzipWriter := &gzipResponseWriter{
+				ResponseWriter: w,
+				minSize:        minSize,
+			}
+			defer gzipWriter.close()
+
+			next.ServeHTTP(gzipWriter, r)
+		})
+	}
+}
+
+// Records the status of a response for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	return s.ResponseWriter.Write(b)
+}
+
+// Forward flushes, so that streamed responses are not held back
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) getStatus() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+
+	return s.status
+}
+
+// Compresses the body of a response. Responses which declare a length below
+// the minimum size are sent as they are.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize     int
+	gzipper     *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+
+	g.wroteHeader = true
+
+	header := g.Header()
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	isSmall := err == nil && length < g.minSize
+	hasBody := status != http.StatusNoContent &&
+		status != http.StatusNotModified
+	if hasBody && !isSmall && header.Get("Content-Encoding") == "" {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		g.gzipper = gzip.NewWriter(g.ResponseWriter)
+	}
+
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+
+	if g.gzipper == nil {
+		return g.ResponseWriter.Write(b)
+	}
+
+	return g.gzipper.Write(b)
+}
+
+// Send the data which is buffered by the compressor before flushing the
+// response
+func (g *gzipResponseWriter) Flush() {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+
+	if g.gzipper != nil {
+		g.gzipper.Flush()
+	}
+
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) close() {
+	if g.gzipper != nil {
+		g.gzipper.Close()
+	}
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+
+		// A zero quality value refuses the encoding
+		params = strings.ReplaceAll(params, " ", "")
+		return params != "q=0" && params != "q=0.0" && params != "q=0.00" &&
+			params != "q=0.000"
+	}
+
+	return false
+}
+
+// Token bucket per client. Buckets which have been full for a while are
+// removed so that the map doesn't grow with every client ever seen.
+type rateLimiter struct {
+	mutex     sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   math.Max(burst, 1),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Take a token from the bucket of the client. Returns how long the client
+// has to wait when the bucket is empty.
+func (l *rateLimiter) allow(key string, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updatedAt: now}
+		l.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.updatedAt).Seconds()
+	bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+	bucket.updatedAt = now
+
+	if bucket.tokens < 1 {
+		wait := (1 - bucket.tokens) / l.rate
+		return time.Duration(wait * float64(time.Second))
+	}
+
+	bucket.tokens--
+	return 0
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updatedAt) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// API keys are limited separately from the sessions of their owner
+func getRateLimitKey(r *http.Request) string {
+	principal := core.GetPrincipal(r.Context())
+	if principal == nil {
+		return "ip:" + getClientIP(r)
+	}
+
+	if principal.ApiKeyId != "" {
+		return "apikey:" + principal.ApiKeyId
+	}
+
+	return "user:" + principal.UserId
+}
+
+func isValidRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > 128 {
+		return false
+	}
+
+	for _, c := range requestId {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestId() string {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// Return the id given to the request by the request_id middleware
+func getRequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(values.ContextKeyRequestId).(string)
+	return requestId
+}
+
+func writeErrorResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	response util.ErrorResponse,
+) {
+	response.RequestId = getRequestId(r.Context())
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/nordluma/go-bookstore/util"
+)
+
+// Set the request timeout for the test and restore it when the test ends
+func setTestRequestTimeout(t *testing.T, timeout string) {
+	t.Helper()
+
+	previous := viper.Get("http.request_timeout")
+	viper.Set("http.request_timeout", timeout)
+	t.Cleanup(func() { viper.Set("http.request_timeout", previous) })
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	setTestRequestTimeout(t, "10ms")
+
+	// The handler ignores the cancelled context
+	slowHandler := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/api/book/all", nil)
+	timeoutHandler := newTimeoutMiddleware()(http.HandlerFunc(slowHandler))
+	timeoutHandler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", recorder.Code)
+	}
+
+	contentType := recorder.Header().Get("Content-Type")
+	if contentType != "application/json; charset=utf-8" {
+		t.Errorf("Expected a JSON response, got %q", contentType)
+	}
+
+	response := util.ErrorResponse{}
+	err := json.NewDecoder(recorder.Body).Decode(&response)
+	if err != nil || response.ErrorCode != util.ErrorCodeUnavailable {
+		t.Errorf("Expected an unavailable error, got %+v %v", response, err)
+	}
+}
+
+func TestTimeoutMiddlewareKeepsFastResponses(t *testing.T) {
+	setTestRequestTimeout(t, "1s")
+
+	fastHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/api/book/all", nil)
+	timeoutHandler := newTimeoutMiddleware()(http.HandlerFunc(fastHandler))
+	timeoutHandler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusServiceUnavailable ||
+		recorder.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf(
+			"Expected the response of the handler, got %d %q",
+			recorder.Code,
+			recorder.Header().Get("Content-Type"),
+		)
+	}
+}
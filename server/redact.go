@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nordluma/go-bookstore/config"
+	handler "github.com/nordluma/go-bookstore/handler"
+)
+
+// Value which replaces masked fields in the log
+const redacted = "[REDACTED]"
+
+// Value which replaces bodies which can't be masked because they are not
+// JSON
+const unparseableBody = "[unparseable body redacted]"
+
+// Field of a route which masks the whole body
+const redactAll = "*"
+
+// Masks secrets, such as passwords and tokens, in bodies and query strings
+// before they are logged
+type redactor struct {
+	logBodies   bool
+	maxBodySize int
+	fields      map[string]bool
+	routes      []redactRoute
+}
+
+type redactRoute struct {
+	pattern string
+	fields  map[string]bool
+}
+
+func newRedactor() *redactor {
+	r := &redactor{
+		logBodies:   config.GetLogBodies(),
+		maxBodySize: config.GetLogBodyMaxSize(),
+		fields:      toFieldSet(config.GetLogRedactFields()),
+	}
+
+	for pattern, fields := range config.GetLogRedactRoutes() {
+		r.routes = append(r.routes, redactRoute{
+			pattern: strings.ToLower(pattern),
+			fields:  toFieldSet(fields),
+		})
+	}
+
+	return r
+}
+
+// Return the body with its secrets masked, or an empty string if bodies are
+// not logged. Bodies which are not JSON are replaced, since their secrets
+// can't be found.
+func (r *redactor) redactBody(path, body string) string {
+	if !r.logBodies || body == "" {
+		return ""
+	}
+
+	routeFields := r.getRouteFields(path)
+	if routeFields[redactAll] {
+		return redacted
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil || decoder.More() {
+		return unparseableBody
+	}
+
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+
+	err = encoder.Encode(r.redactValue(value, routeFields))
+	if err != nil {
+		return unparseableBody
+	}
+
+	return r.truncate(trimEOL(buffer.String()))
+}
+
+// Return the URI with the values of secret query parameters masked
+func (r *redactor) redactURI(uri string) string {
+	path, rawQuery, found := strings.Cut(uri, "?")
+	if !found {
+		return uri
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return path + "?" + redacted
+	}
+
+	routeFields := r.getRouteFields(path)
+	for name, values := range query {
+		if !r.isRedacted(name, routeFields) {
+			continue
+		}
+
+		for i := range values {
+			values[i] = redacted
+		}
+	}
+
+	// Keep the mask readable instead of escaping its brackets
+	escaped := url.QueryEscape(redacted)
+	return path + "?" + strings.ReplaceAll(query.Encode(), escaped, redacted)
+}
+
+func (r *redactor) redactValue(
+	value interface{},
+	routeFields map[string]bool,
+) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for name, fieldValue := range value {
+			if r.isRedacted(name, routeFields) {
+				value[name] = redacted
+				continue
+			}
+
+			value[name] = r.redactValue(fieldValue, routeFields)
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = r.redactValue(element, routeFields)
+		}
+	}
+
+	return value
+}
+
+func (r *redactor) isRedacted(name string, routeFields map[string]bool) bool {
+	name = strings.ToLower(name)
+	return r.fields[name] || routeFields[name]
+}
+
+// Return the fields which are masked in addition for the route of the path
+func (r *redactor) getRouteFields(path string) map[string]bool {
+	path = strings.ToLower(path)
+	for _, route := range r.routes {
+		if handler.MatchPath(route.pattern, path) {
+			return route.fields
+		}
+	}
+
+	return nil
+}
+
+func (r *redactor) truncate(body string) string {
+	if r.maxBodySize <= 0 || len(body) <= r.maxBodySize {
+		return body
+	}
+
+	// Don't cut a multi-byte character in half
+	n := r.maxBodySize
+	for n > 0 && !utf8.RuneStart(body[n]) {
+		n--
+	}
+
+	return fmt.Sprintf("%v...(%d bytes truncated)", body[:n], len(body)-n)
+}
+
+func toFieldSet(fields []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, field := range fields {
+		set[strings.ToLower(strings.TrimSpace(field))] = true
+	}
+
+	return set
+}
@@ -2,16 +2,14 @@ package server
 
 import (
 	"bytes"
-	"compress/gzip"
-	"context"
 	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	handler "github.com/nordluma/go-bookstore/handler"
 	"github.com/nordluma/go-bookstore/util"
@@ -20,10 +18,12 @@ import (
 type handlerAPI struct {
 	requestPool *sync.Pool // holds: *handler.Request
 	bufferPool  *sync.Pool // holds: *bytes.Buffer
+	redactor    *redactor
 }
 
 func newHandlerAPI() *handlerAPI {
 	return &handlerAPI{
+		redactor: newRedactor(),
 		requestPool: &sync.Pool{
 			New: func() interface{} {
 				return new(handler.Request)
@@ -41,11 +41,8 @@ func (handlerAPI *handlerAPI) ServeHTTP(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	// start timer to measure request durations
-	startTime := time.Now()
-
-	// prepare request context
-	ctx := context.Background()
+	// the middleware has prepared the request context
+	ctx := r.Context()
 
 	authorization := r.Header.Get("Authorization")
 	requestBody, logRequestBody := handlerAPI.getRequestBody(r.Body)
@@ -55,6 +52,8 @@ func (handlerAPI *handlerAPI) ServeHTTP(
 	request.Body = requestBody
 	request.URL = r.URL
 	request.Method = r.Method
+	request.ClientIP = getClientIP(r)
+	request.ResponseHeader = w.Header()
 
 	// response for the request generated by core layer function
 	var response interface{}
@@ -67,20 +66,18 @@ func (handlerAPI *handlerAPI) ServeHTTP(
 		// return pooled values back to the appropriate `sync.Pool`
 		handlerAPI.requestPool.Put(request)
 
-		// Log the request
-		duration := time.Now().Sub(startTime)
-		log.Printf(
-			"%v: status=%v method=%v uri=%v durations=%v",
-			startTime,
-			httpResponseStatus,
-			r.Method,
-			r.RequestURI,
-			duration,
-		)
-		log.Printf("request=%v", logRequestBody)
+		if !handlerAPI.redactor.logBodies {
+			return
+		}
+
+		// secrets in the bodies must not end up in the log
+		redactor, path := handlerAPI.redactor, r.URL.Path
+		logRequestBody = redactor.redactBody(path, logRequestBody)
+		slog.InfoContext(ctx, "request body", "body", logRequestBody)
 
 		if response != nil {
-			log.Printf("response=%v", logResponseBody)
+			logResponseBody = redactor.redactBody(path, logResponseBody)
+			slog.InfoContext(ctx, "response body", "body", logResponseBody)
 		}
 	}()
 
@@ -88,11 +85,15 @@ func (handlerAPI *handlerAPI) ServeHTTP(
 	if err == nil {
 		httpResponseStatus = http.StatusOK
 	} else {
+		util.LogError(ctx, err)
+
 		isError, errorCode, cause, errorType := util.IsError(err)
 		if isError == true {
 			response = util.ErrorResponse{
 				ErrorCode: errorCode,
 				Cause:     cause,
+				Reason:    util.MapErrorTypeToReason(errorType),
+				RequestId: getRequestId(ctx),
 			}
 
 			httpResponseStatus = util.MapErrorTypeToHTTPStatus(errorType)
@@ -106,19 +107,13 @@ func (handlerAPI *handlerAPI) ServeHTTP(
 	responseBuffer.Reset()
 
 	if response != nil {
-		shouldGzip := err == nil
 		logResponseBody = handlerAPI.makeResponsebody(
-			shouldGzip,
 			responseBuffer,
 			response,
 		)
 
-		if shouldGzip == true {
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-			w.Header().
-				Set("Content-Length", strconv.Itoa(responseBuffer.Len()))
-		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(responseBuffer.Len()))
 	}
 
 	w.WriteHeader(httpResponseStatus)
@@ -127,6 +122,16 @@ func (handlerAPI *handlerAPI) ServeHTTP(
 	handlerAPI.bufferPool.Put(responseBuffer)
 }
 
+// Return the IP address of the client without the port
+func getClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
 func (handlerAPI *handlerAPI) getRequestBody(
 	reader io.Reader,
 ) (io.Reader, string) {
@@ -142,7 +147,6 @@ func (handlerAPI *handlerAPI) getRequestBody(
 }
 
 func (handlerAPI *handlerAPI) makeResponsebody(
-	shouldGzip bool,
 	writer io.Writer,
 	response interface{},
 ) string {
@@ -153,13 +157,7 @@ func (handlerAPI *handlerAPI) makeResponsebody(
 	respRawBody := handlerAPI.bufferPool.Get().(*bytes.Buffer)
 	respRawBody.Reset()
 
-	if shouldGzip {
-		gzipper := gzip.NewWriter(writer)
-		writeResponse(io.MultiWriter(respRawBody, gzipper), response)
-		gzipper.Close()
-	} else {
-		writeResponse(io.MultiWriter(respRawBody, writer), response)
-	}
+	writeResponse(io.MultiWriter(respRawBody, writer), response)
 
 	rawBody := trimEOL(respRawBody.String())
 	handlerAPI.bufferPool.Put(respRawBody)
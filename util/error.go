@@ -1,62 +1,145 @@
 package util
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"strings"
 )
 
 var (
-	ErrBadRequest       = errors.New("Bad request")
-	ErrInternal         = errors.New("Internal error")
-	ErrInvalidAPICall   = errors.New("Invalid API call")
-	ErrNotAuthenticated = errors.New("Not authenticated")
-	ErrResourceNotFound = errors.New("Resource not found")
+	ErrBadRequest         = errors.New("Bad request")
+	ErrConflict           = errors.New("Conflict")
+	ErrForbidden          = errors.New("Forbidden")
+	ErrInternal           = errors.New("Internal error")
+	ErrInvalidAPICall     = errors.New("Invalid API call")
+	ErrMethodNotAllowed   = errors.New("Method not allowed")
+	ErrNotAuthenticated   = errors.New("Not authenticated")
+	ErrResourceNotFound   = errors.New("Resource not found")
+	ErrServiceUnavailable = errors.New("Service unavailable")
+	ErrTooManyRequests    = errors.New("Too many requests")
 
 	MapErrorTypeToHTTPStatus = mapErrorTypeToHTTPStatus
+	MapErrorTypeToReason     = mapErrorTypeToReason
 	IsError                  = isError
 	NewError                 = newError
+
+	// Log an error which is returned to a client with the errors which
+	// caused it
+	LogError = logError
 )
 
 const (
 	ErrorCodeInternal           = 0
 	ErrorCodeInvalidJSONBody    = 30
 	ErrorCodeInvalidCredentials = 201
+	ErrorCodeAccountLocked      = 202
+	ErrorCodeLoginThrottled     = 203
+	ErrorCodeOidcLoginFailed    = 204
+	ErrorCodePermissionDenied   = 205
 	ErrorCodeEntityNotFound     = 404
+	ErrorCodeConflict           = 409
 	ErrorCodeValidation         = 500
+	ErrorCodeUnavailable        = 503
+
+	ErrorCodeRenewalLimitReached = 601
+	ErrorCodeTitleOnHold         = 602
+	ErrorCodeFineBalanceExceeded = 603
+	ErrorCodeLoanLimitReached    = 604
+	ErrorCodeOverdueLoans        = 605
+	ErrorCodeMembershipExpired   = 606
+)
+
+// Reasons tell clients how to recover from an error without parsing its
+// cause
+const (
+	ReasonBadRequest             = "bad_request"
+	ReasonAuthenticationRequired = "authentication_required"
+	ReasonPermissionDenied       = "permission_denied"
+	ReasonNotFound               = "not_found"
+	ReasonMethodNotAllowed       = "method_not_allowed"
+	ReasonConflict               = "conflict"
+	ReasonTooManyRequests        = "too_many_requests"
+	ReasonInternal               = "internal"
+	ReasonUnavailable            = "unavailable"
 )
 
 type ErrorResponse struct {
 	ErrorCode int
 	Cause     string
+	Reason    string
+	RequestId string `json:",omitempty"`
 }
 
 type serverError struct {
 	code      int
 	cause     string
 	errorType error
+	err       error
 }
 
 func (e serverError) Error() string {
 	return e.cause
 }
 
+func (e serverError) Unwrap() error {
+	return e.err
+}
+
 // Map our error types to HTTP status codes
 func mapErrorTypeToHTTPStatus(err error) int {
 	switch err {
 	case ErrBadRequest:
 		return http.StatusBadRequest
+	case ErrConflict:
+		return http.StatusConflict
+	case ErrForbidden:
+		return http.StatusForbidden
 	case ErrInternal:
 		return http.StatusInternalServerError
 	case ErrInvalidAPICall, ErrResourceNotFound:
 		return http.StatusNotFound
+	case ErrMethodNotAllowed:
+		return http.StatusMethodNotAllowed
 	case ErrNotAuthenticated:
 		return http.StatusUnauthorized
+	case ErrServiceUnavailable:
+		return http.StatusServiceUnavailable
+	case ErrTooManyRequests:
+		return http.StatusTooManyRequests
 	default:
 		return http.StatusInternalServerError
 	}
 }
 
+// Map our error types to the reasons which are returned to clients
+func mapErrorTypeToReason(err error) string {
+	switch err {
+	case ErrBadRequest:
+		return ReasonBadRequest
+	case ErrConflict:
+		return ReasonConflict
+	case ErrForbidden:
+		return ReasonPermissionDenied
+	case ErrInvalidAPICall, ErrResourceNotFound:
+		return ReasonNotFound
+	case ErrMethodNotAllowed:
+		return ReasonMethodNotAllowed
+	case ErrNotAuthenticated:
+		return ReasonAuthenticationRequired
+	case ErrServiceUnavailable:
+		return ReasonUnavailable
+	case ErrTooManyRequests:
+		return ReasonTooManyRequests
+	default:
+		return ReasonInternal
+	}
+}
+
 // Return underlying error type
 func isError(errorType error) (bool, int, string, error) {
 	err, IsError := errorType.(serverError)
@@ -67,13 +150,88 @@ func isError(errorType error) (bool, int, string, error) {
 	return true, err.code, err.cause, err.errorType
 }
 
-// Create new error
+// Create new error. Internal errors which are caused by the database being
+// unreachable are reported as unavailable so that clients can retry later.
+// The error is logged once it reaches the client, see LogError.
 func newError(cause string, code int, errorType, err error) error {
-	if err != nil {
-		log.Printf("error: %v: %v", cause, err)
-	} else {
-		log.Printf("error: %v", cause)
+	if errorType == ErrInternal && isUnavailable(err) {
+		code, errorType = ErrorCodeUnavailable, ErrServiceUnavailable
+	}
+
+	return serverError{code, cause, errorType, err}
+}
+
+// Errors of the server are logged as errors, errors of the client only as
+// information
+func logError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+
+	level := slog.LevelError
+	attrs := []slog.Attr{slog.String("error", describeError(err))}
+
+	var serverErr serverError
+	if errors.As(err, &serverErr) {
+		status := mapErrorTypeToHTTPStatus(serverErr.errorType)
+		if status < http.StatusInternalServerError {
+			level = slog.LevelInfo
+		}
+
+		attrs = append(
+			attrs,
+			slog.Int("error_code", serverErr.code),
+			slog.Int("status", status),
+		)
+	}
+
+	slog.LogAttrs(ctx, level, "request failed", attrs...)
+}
+
+// Join the causes of nested errors, from the outermost to the innermost
+func describeError(err error) string {
+	causes := []string{}
+	for err != nil {
+		serverErr, ok := err.(serverError)
+		if !ok {
+			causes = append(causes, err.Error())
+			break
+		}
+
+		causes = append(causes, serverErr.cause)
+		err = serverErr.err
+	}
+
+	return strings.Join(causes, ": ")
+}
+
+// Returns true for errors which are caused by a lost connection, an
+// overloaded or restarting database, or a timeout
+func isUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// Connection exceptions, insufficient resources and operator
+	// intervention such as a shutdown or a cancelled statement
+	var sqlErr interface{ SQLState() string }
+	if errors.As(err, &sqlErr) {
+		state := sqlErr.SQLState()
+		return strings.HasPrefix(state, "08") ||
+			strings.HasPrefix(state, "53") ||
+			strings.HasPrefix(state, "57")
 	}
 
-	return serverError{code, cause, errorType}
+	return false
 }
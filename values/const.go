@@ -1,9 +1,30 @@
 package values
 
 const (
-	UserRoleUnknown   = 0
-	UserRoleMember    = 1
-	UserRoleLibrarian = 2
+	UserRoleUnknown         = 0
+	UserRoleMember          = 1
+	UserRoleLibrarian       = 2
+	UserRoleAdmin           = 3
+	UserRoleCirculationDesk = 4
+	UserRoleCataloguer      = 5
+
+	PermissionBookRead     = "book:read"
+	PermissionBookWrite    = "book:write"
+	PermissionLoanSelf     = "loan:self"
+	PermissionLoanCheckout = "loan:checkout"
+	PermissionFineManage   = "fine:manage"
+	PermissionUserManage   = "user:manage"
+	PermissionRoleManage   = "role:manage"
+	PermissionApiKeyManage = "apikey:manage"
+
+	// Prefix which tells API keys apart from session tokens
+	ApiKeyPrefix = "bsk_"
+
+	UserStatusUnknown   = 0
+	UserStatusActive    = 1
+	UserStatusPending   = 2
+	UserStatusRejected  = 3
+	UserStatusSuspended = 4
 
 	// The amount of rows that can be fetched from database
 	MaxRowLimit = 50
@@ -11,9 +32,42 @@ const (
 	BookStatusUnknown   = 0
 	BookStatusAvailable = 1
 	BookStatusBorrowed  = 2
+	BookStatusReserved  = 3
+
+	HoldStatusUnknown   = 0
+	HoldStatusWaiting   = 1
+	HoldStatusReady     = 2
+	HoldStatusFulfilled = 3
+	HoldStatusCancelled = 4
+	HoldStatusExpired   = 5
+
+	FineEntryTypeUnknown = 0
+	FineEntryTypeCharge  = 1
+	FineEntryTypePayment = 2
+	FineEntryTypeWaiver  = 3
+
+	// Opaque tokens are looked up from the database on every request
+	AuthModeOpaque = "opaque"
+	// Signed tokens carry the user id and role and are verified locally
+	AuthModeSigned = "signed"
+
+	// Schemes of the Authorization header
+	AuthSchemeBearer = "Bearer"
+	AuthSchemeApiKey = "ApiKey"
+	AuthSchemeBasic  = "Basic"
 )
 
 // A key for context.Context to extract db runner
 var ContextKeyDbRunner = contextKeyDbRunner{}
 
 type contextKeyDbRunner struct{}
+
+// A key for context.Context to extract the authenticated user of a request
+var ContextKeyPrincipal = contextKeyPrincipal{}
+
+type contextKeyPrincipal struct{}
+
+// A key for context.Context to extract the id of a request
+var ContextKeyRequestId = contextKeyRequestId{}
+
+type contextKeyRequestId struct{}